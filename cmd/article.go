@@ -5,7 +5,11 @@ import (
 	s "github.com/jktr/go-strichliste"
 	"github.com/jktr/go-strichliste/schema"
 	"github.com/spf13/cobra"
+	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func newArticleCommand(cli *CLI) *cobra.Command {
@@ -14,13 +18,17 @@ func newArticleCommand(cli *CLI) *cobra.Command {
 		Short: "interact with the article database",
 		Args:  cobra.MaximumNArgs(1),
 		RunE:  cli.wrap(runArticleGet),
+
+		ValidArgsFunction: cli.wrapCompletion(firstArg(completeArticleNames)),
 	}
 
+	cmd.Flags().Bool("include-inactive", false, "include disabled articles in search results")
+
 	create := &cobra.Command{
 		Use:   "create",
 		Short: "create a new article",
 		Args:  cobra.NoArgs,
-		RunE:  cli.wrap(runArticleCreate),
+		RunE:  cli.wrap(runPreHooks, runArticleCreate, runPostHooks),
 	}
 
 	create.Flags().String("name", "", "article's name")
@@ -32,26 +40,70 @@ func newArticleCommand(cli *CLI) *cobra.Command {
 	create.Flags().String("barcode", "", "article's barcode")
 
 	update := &cobra.Command{
-		Use:   "update",
+		Use:   "update <article>",
 		Short: "update an article's metadata",
 		Args:  cobra.ExactArgs(1),
-		RunE:  cli.wrap(runArticleUpdate),
+		RunE:  cli.wrap(runPreHooks, runArticleUpdate, runPostHooks),
+
+		ValidArgsFunction: cli.wrapCompletion(firstArg(completeArticleIDs)),
 	}
 
 	update.Flags().String("set-name", "", "article's new name")
 	update.Flags().Float64("set-value", 0, "article's new value")
 	update.Flags().String("set-barcode", "", "article's new barcode")
+	update.Flags().Bool("clear-barcode", false, "remove the article's barcode")
+	update.Flags().Bool("dry-run", false, "only show what would change")
 
 	delete := &cobra.Command{
-		Use:   "delete",
+		Use:   "delete <article>",
 		Short: "delete/disable an article",
 		Args:  cobra.ExactArgs(1),
-		RunE:  cli.wrap(runArticleDelete),
+		RunE:  cli.wrap(runPreHooks, runArticleDelete, runPostHooks),
+
+		ValidArgsFunction: cli.wrapCompletion(firstArg(completeArticleIDs)),
 	}
 
 	delete.Flags().Bool("confirm", false, "confirm deletion; dry-runs otherwise")
 
-	cmd.AddCommand(create, update, delete)
+	restore := &cobra.Command{
+		Use:   "restore <article>",
+		Short: "re-enable a disabled article",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cli.wrap(runPreHooks, runArticleRestore, runPostHooks),
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "list all articles as a table",
+		Args:  cobra.NoArgs,
+		RunE:  cli.wrap(runArticleList),
+	}
+
+	list.Flags().Bool("include-inactive", false, "include disabled articles")
+	list.Flags().Bool("only-inactive", false, "only show disabled articles")
+	list.Flags().Float64("min-value", 0, "only show articles worth at least this much")
+	list.Flags().Float64("max-value", 0, "only show articles worth at most this much")
+	list.Flags().Bool("with-barcode", false, "only show articles with a barcode")
+	list.Flags().Bool("without-barcode", false, "only show articles without a barcode")
+	list.Flags().String("sort", "name", "sort by name, id, value or activity")
+	list.Flags().Bool("reverse", false, "reverse the sort order")
+
+	label := &cobra.Command{
+		Use:   "label [article...]",
+		Short: "generate barcode labels for articles",
+		Long: `generate barcode labels for articles
+
+Articles without a barcode are assigned an EAN-13 barcode from the
+range reserved for in-store use (starting with 20), which is saved.`,
+		Args: cobra.ArbitraryArgs,
+		RunE: cli.wrap(runPreHooks, runArticleLabel, runPostHooks),
+
+		ValidArgsFunction: cli.wrapCompletion(completeArticleNames),
+	}
+
+	addLabelFlags(label, "ean13|code128|qr; default: ean13 for EAN barcodes, code128 otherwise")
+
+	cmd.AddCommand(create, update, delete, restore, list, label)
 	return cmd
 }
 
@@ -90,9 +142,15 @@ func runArticleGet(cli *CLI, cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	settings, _, err := cli.Client.Settings.Get()
+	// explicitly requested IDs are always shown
+	includeInactive, _ := cmd.Flags().GetBool("include-inactive")
+	if aid == 0 && !includeInactive {
+		articles = activeArticles(articles)
+	}
+
+	settings, err := cli.Settings()
 	if err != nil {
-		return nil
+		return err
 	}
 
 	for _, article := range articles {
@@ -126,75 +184,111 @@ func runArticleCreate(cli *CLI, cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	cli.result = article
 	fmt.Printf("created article #%d (%s)\n", article.ID, article.Name)
 	return nil
 }
 
 func runArticleUpdate(cli *CLI, cmd *cobra.Command, args []string) error {
 
-	newName, _ := cmd.Flags().GetString("set-name")
-	floatValue, _ := cmd.Flags().GetFloat64("set-value")
-	newBarcode, _ := cmd.Flags().GetString("set-barcode")
+	flags := cmd.Flags()
 
-	newValue := CurrencyFloat64ToInt(floatValue)
+	if flags.Changed("set-barcode") && flags.Changed("clear-barcode") {
+		return newError(ErrorUsage, "--set-barcode and --clear-barcode are mutually exclusive")
+	}
 
-	articleId, err := strconv.Atoi(args[0])
+	if !flags.Changed("set-name") && !flags.Changed("set-value") &&
+		!flags.Changed("set-barcode") && !flags.Changed("clear-barcode") {
+		return newError(ErrorUsage, "no updates requested")
+	}
+
+	article, err := resolveArticle(cli, args[0])
 	if err != nil {
 		return err
 	}
 
-	article, _, err := cli.Client.Article.Get(articleId)
+	settings, err := cli.Settings()
 	if err != nil {
 		return err
 	}
 
-	// don't do anything if nothing changed
-	if newName == "" && newBarcode == "" && newValue == 0 {
-		fmt.Printf("no updates requested for article #%d (%s)\n", article.ID, article.Name)
-		return cmd.Usage()
+	// the API replaces all fields at once, so we start
+	// from the current ones and resend unchanged ones
+	update := &schema.ArticleUpdateRequest{
+		Name:  article.Name,
+		Value: article.Value,
+	}
+	if article.Barcode != nil {
+		update.Barcode = *article.Barcode
 	}
 
-	// XXX partial update isn't allowed unless the name + value are set,
-	// so we set them to their (hopefully still) current value :/
-
-	if newName == "" {
-		newName = article.Name
+	if flags.Changed("set-name") {
+		update.Name, _ = flags.GetString("set-name")
+	}
+	if flags.Changed("set-value") {
+		floatValue, _ := flags.GetFloat64("set-value")
+		update.Value = CurrencyFloat64ToInt(floatValue)
 	}
-	if newValue == 0 {
-		newValue = article.Value
+	if flags.Changed("set-barcode") {
+		update.Barcode, _ = flags.GetString("set-barcode")
+	}
+	if clear, _ := flags.GetBool("clear-barcode"); clear {
+		update.Barcode = "" // omitted, and thus removed
+	}
+
+	before := ""
+	if article.Barcode != nil {
+		before = *article.Barcode
 	}
 
-	updatedArticle, _, err := cli.Client.Article.Update(articleId, &schema.ArticleUpdateRequest{
-		Name:    newName,
-		Value:   newValue,
-		Barcode: newBarcode,
+	header := fmt.Sprintf("article #%d (%s):", article.ID, article.Name)
+	changed := printChanges(header, []fieldChange{
+		{"name", quoteValue(article.Name), quoteValue(update.Name)},
+		{"value", formatCurrency(settings, article.Value), formatCurrency(settings, update.Value)},
+		{"barcode", quoteValue(before), quoteValue(update.Barcode)},
 	})
+
+	if !changed {
+		fmt.Printf("no changes for article #%d (%s)\n", article.ID, article.Name)
+		return nil
+	}
+
+	dryRun, _ := flags.GetBool("dry-run")
+	if dryRun {
+		return newError(ErrorDryRun, "would update article #%d (%s)",
+			article.ID, article.Name)
+	}
+
+	updatedArticle, _, err := cli.Client.Article.Update(article.ID, update)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("update article #%d (%s)\n", updatedArticle.ID, updatedArticle.Name)
+	cli.result = updatedArticle
+
+	// updating referenced articles creates a new version
+	if updatedArticle.ID != article.ID {
+		fmt.Printf("updated article #%d (%s), replacing #%d\n",
+			updatedArticle.ID, updatedArticle.Name, article.ID)
+	} else {
+		fmt.Printf("updated article #%d (%s)\n", updatedArticle.ID, updatedArticle.Name)
+	}
 	return nil
 }
 
 func runArticleDelete(cli *CLI, cmd *cobra.Command, args []string) error {
-	articleId, err := strconv.Atoi(args[0])
-	if err != nil {
-		return err
-	}
-
-	article, _, err := cli.Client.Article.Get(articleId)
+	article, err := resolveArticle(cli, args[0])
 	if err != nil {
 		return err
 	}
 
 	if !article.IsActive {
-		return fmt.Errorf("article is already disabled")
+		return newError(ErrorValidation, "article is already disabled")
 	}
 
 	confirmed, _ := cmd.Flags().GetBool("confirm")
 	if !confirmed {
-		return fmt.Errorf("dry-run: would delete article #%d (%s)",
+		return newError(ErrorDryRun, "would delete article #%d (%s)",
 			article.ID, article.Name)
 	}
 
@@ -204,9 +298,327 @@ func runArticleDelete(cli *CLI, cmd *cobra.Command, args []string) error {
 	}
 
 	if article.IsActive {
-		return fmt.Errorf("failed to disable article")
+		return newError(ErrorServer, "failed to disable article")
 	}
 
+	cli.result = article
 	fmt.Printf("disabled article #%d (%s)\n", article.ID, article.Name)
 	return nil
 }
+
+func runArticleList(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	flags := cmd.Flags()
+	sortBy, _ := flags.GetString("sort")
+	reverse, _ := flags.GetBool("reverse")
+	includeInactive, _ := flags.GetBool("include-inactive")
+	onlyInactive, _ := flags.GetBool("only-inactive")
+	minValue, _ := flags.GetFloat64("min-value")
+	maxValue, _ := flags.GetFloat64("max-value")
+	withBarcode, _ := flags.GetBool("with-barcode")
+	withoutBarcode, _ := flags.GetBool("without-barcode")
+
+	// articles are replaced by new versions when updated,
+	// so their creation time is their last activity
+	var less func(a, b *schema.Article) bool
+	switch sortBy {
+	case "name":
+		less = func(a, b *schema.Article) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case "id":
+		less = func(a, b *schema.Article) bool { return a.ID < b.ID }
+	case "value":
+		less = func(a, b *schema.Article) bool { return a.Value < b.Value }
+	case "activity":
+		less = func(a, b *schema.Article) bool {
+			return time.Time(a.TimeCreated).Before(time.Time(b.TimeCreated))
+		}
+	default:
+		return newError(ErrorUsage, "can't sort articles by '%s'", sortBy)
+	}
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return err
+	}
+
+	articles, err := listArticles(cli)
+	if err != nil {
+		return err
+	}
+
+	var filtered []schema.Article
+	for _, article := range articles {
+		switch {
+		case onlyInactive && article.IsActive:
+			continue
+		case !onlyInactive && !includeInactive && !article.IsActive:
+			continue
+		case flags.Changed("min-value") && article.Value < CurrencyFloat64ToInt(minValue):
+			continue
+		case flags.Changed("max-value") && article.Value > CurrencyFloat64ToInt(maxValue):
+			continue
+		case withBarcode && article.Barcode == nil:
+			continue
+		case withoutBarcode && article.Barcode != nil:
+			continue
+		}
+		filtered = append(filtered, article)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if reverse {
+			return less(&filtered[j], &filtered[i])
+		}
+		return less(&filtered[i], &filtered[j])
+	})
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "ID\tNAME\tVALUE\tBARCODE\tACTIVE\tCREATED")
+	for _, article := range filtered {
+		barcode := ""
+		if article.Barcode != nil {
+			barcode = *article.Barcode
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%t\t%s\n",
+			article.ID,
+			article.Name,
+			formatCurrency(settings, article.Value),
+			barcode,
+			article.IsActive,
+			formatTime(localTime(article.TimeCreated)),
+		)
+	}
+	return w.Flush()
+}
+
+// go-strichliste's ArticleUpdateRequest lacks the active flag
+type articleRestoreRequest struct {
+	schema.ArticleUpdateRequest
+	IsActive bool `json:"active"`
+}
+
+func runArticleRestore(cli *CLI, cmd *cobra.Command, args []string) error {
+	article, err := resolveInactiveArticle(cli, args[0])
+	if err != nil {
+		return err
+	}
+
+	if article.IsActive {
+		return newError(ErrorValidation, "article is already active")
+	}
+
+	// all fields are replaced on update, so resend them
+	restore := &articleRestoreRequest{
+		ArticleUpdateRequest: schema.ArticleUpdateRequest{
+			Name:  article.Name,
+			Value: article.Value,
+		},
+		IsActive: true,
+	}
+	if article.Barcode != nil {
+		restore.Barcode = *article.Barcode
+	}
+
+	req, err := cli.Client.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/%d", schema.EndpointArticle, article.ID), restore)
+	if err != nil {
+		return err
+	}
+
+	var body schema.SingleArticleResponse
+	_, err = cli.Client.Do(req, &body)
+	if err != nil {
+		return err
+	}
+	restored := &body.Article
+
+	if !restored.IsActive {
+		return newError(ErrorServer, "failed to re-enable article")
+	}
+
+	cli.result = restored
+
+	// as with update, a referenced article gets a new version
+	if restored.ID != article.ID {
+		fmt.Printf("re-enabled article #%d (%s), replacing #%d\n",
+			restored.ID, restored.Name, article.ID)
+	} else {
+		fmt.Printf("re-enabled article #%d (%s)\n", restored.ID, restored.Name)
+	}
+	return nil
+}
+
+func runArticleLabel(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	all, _ := cmd.Flags().GetBool("all")
+	format, _ := cmd.Flags().GetString("format")
+	dest, _ := cmd.Flags().GetString("dest")
+	kind, _ := cmd.Flags().GetString("type")
+
+	// check before assigning any barcodes
+	if !containsString([]string{"pdf", "svg", "png"}, format) {
+		return newError(ErrorUsage, "unknown format '%s'", format)
+	}
+	if kind != "" && !containsString([]string{"ean13", "code128", "qr"}, kind) {
+		return newError(ErrorUsage, "unknown barcode type '%s'", kind)
+	}
+	if len(args) == 0 && !all {
+		return newError(ErrorUsage, "no articles given; use --all for all of them")
+	}
+
+	var articles []*schema.Article
+	if all {
+		list, err := listArticles(cli)
+		if err != nil {
+			return err
+		}
+		for _, article := range activeArticles(list) {
+			article := article
+			articles = append(articles, &article)
+		}
+	}
+	for _, arg := range args {
+		article, err := resolveArticle(cli, arg)
+		if err != nil {
+			return err
+		}
+		articles = append(articles, article)
+	}
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return err
+	}
+
+	var taken map[string]bool
+	var assigned []*schema.Article
+	var labels []label
+	for _, article := range articles {
+
+		if article.Barcode == nil || *article.Barcode == "" {
+			if taken == nil {
+				taken, err = takenBarcodes(cli)
+				if err != nil {
+					return err
+				}
+			}
+
+			article, err = assignBarcode(cli, article, taken)
+			if err != nil {
+				return err
+			}
+			assigned = append(assigned, article)
+		}
+
+		code := *article.Barcode
+		codeKind := kind
+		if codeKind == "" {
+			codeKind = "code128"
+			if isEAN(code) {
+				codeKind = "ean13"
+			}
+		}
+
+		encoded, err := encodeBarcode(codeKind, code)
+		if err != nil {
+			return err
+		}
+
+		labels = append(labels, label{
+			File:     fmt.Sprintf("article-%d", article.ID),
+			Title:    article.Name,
+			Subtitle: formatCurrency(settings, article.Value),
+			Code:     encoded,
+		})
+	}
+
+	if len(assigned) > 0 {
+		cli.result = assigned
+	}
+	return writeLabels(labels, articleLabelLayout, format, dest, "article-labels.pdf")
+}
+
+// Returns the barcodes of all articles, including inactive ones.
+func takenBarcodes(cli *CLI) (map[string]bool, error) {
+
+	articles, err := listArticles(cli)
+	if err != nil {
+		return nil, err
+	}
+
+	taken := map[string]bool{}
+	for _, article := range articles {
+		if article.Barcode != nil {
+			taken[*article.Barcode] = true
+		}
+	}
+	return taken, nil
+}
+
+// Saves a new in-store EAN-13 barcode, "20" followed by the article's
+// ID and a check digit, or the next free one after that.
+func assignBarcode(cli *CLI, article *schema.Article, taken map[string]bool) (*schema.Article, error) {
+
+	code := ""
+	for n := article.ID; code == "" || taken[code]; n++ {
+		code = fmt.Sprintf("20%010d", n)
+		code += string(eanCheckDigit(code))
+	}
+
+	// the API replaces all fields at once, so resend the others
+	updated, _, err := cli.Client.Article.Update(article.ID, &schema.ArticleUpdateRequest{
+		Name:    article.Name,
+		Value:   article.Value,
+		Barcode: code,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	taken[code] = true
+	fmt.Printf("assigned barcode %s to article #%d (%s)\n", code, updated.ID, updated.Name)
+	return updated, nil
+}
+
+func activeArticles(articles []schema.Article) []schema.Article {
+	var active []schema.Article
+	for _, article := range articles {
+		if article.IsActive {
+			active = append(active, article)
+		}
+	}
+	return active
+}
+
+func inactiveArticles(articles []schema.Article) []schema.Article {
+	var inactive []schema.Article
+	for _, article := range articles {
+		if !article.IsActive {
+			inactive = append(inactive, article)
+		}
+	}
+	return inactive
+}
+
+// Fetches all articles, including inactive ones, page by page.
+func listArticles(cli *CLI) ([]schema.Article, error) {
+
+	var articles []schema.Article
+	for page := uint(1); ; page++ {
+		batch, _, err := cli.Client.Article.List(&s.ListOpts{Page: page, PerPage: listPageSize})
+		if err != nil {
+			return nil, err
+		}
+
+		// guard against servers that ignore pagination
+		if len(batch) > 0 && len(articles) > 0 && batch[0].ID == articles[0].ID {
+			break
+		}
+
+		articles = append(articles, batch...)
+		if len(batch) < listPageSize {
+			break
+		}
+	}
+	return articles, nil
+}
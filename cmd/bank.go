@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/jktr/go-strichliste/schema"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Bank transfer deposits are paid to the account configured under
+// "bank" in the config file:
+//
+//	"bank": {
+//	  "name": "Hackerspace e.V.",
+//	  "iban": "DE02 1203 0000 0000 2020 51",
+//	  "bic": "BYLADEM1001",
+//	  "reference-prefix": "SL"
+//	}
+//
+// Payers use a reference like "SL-42 alice", which identifies user #42
+// when importing bank statements. The name must match too, so that a
+// typo in the ID doesn't credit somebody else.
+type bankConfig struct {
+	Name            string
+	IBAN            string
+	BIC             string
+	ReferencePrefix string `mapstructure:"reference-prefix"`
+}
+
+func loadBankConfig(cli *CLI) (*bankConfig, error) {
+
+	var config bankConfig
+	err := cli.Viper.UnmarshalKey("bank", &config)
+	if err != nil {
+		return nil, newError(ErrorUsage, "invalid bank config: %s", err)
+	}
+
+	if config.ReferencePrefix == "" {
+		config.ReferencePrefix = "SL"
+	}
+	return &config, nil
+}
+
+// The reference with which users pay, e.g. "SL-42 alice".
+func (b *bankConfig) reference(user *schema.User) string {
+	return fmt.Sprintf("%s-%d %s", b.ReferencePrefix, user.ID, user.Name)
+}
+
+// Finds the user ID in a bank transfer's reference. Banks like to
+// break references into lines or mangle their case, so this is lenient.
+func (b *bankConfig) matchReference(reference string) (int, bool) {
+
+	pattern := regexp.MustCompile(`(?i)(?:^|[^\pL\d])` +
+		regexp.QuoteMeta(b.ReferencePrefix) + `[-\s]?(\d+)\b`)
+
+	m := pattern.FindStringSubmatch(reference)
+	if m == nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(m[1])
+	return id, err == nil
+}
+
+// Whether a reference names the user. Banks also break names into
+// lines, so only letters and digits are compared.
+func (b *bankConfig) namesUser(reference string, user *schema.User) bool {
+
+	letters := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, s)
+	}
+
+	name := letters(user.Name)
+	return name != "" && strings.Contains(letters(reference), name)
+}
+
+// Normalizes an IBAN and checks its check digits, as per ISO 13616.
+func normalizeIBAN(iban string) (string, error) {
+
+	iban = strings.ToUpper(strings.Join(strings.Fields(iban), ""))
+	if len(iban) < 15 || len(iban) > 34 {
+		return "", newError(ErrorValidation, "invalid IBAN '%s'", iban)
+	}
+
+	// move the country code and check digits to the end,
+	// then replace letters with numbers: A = 10, B = 11, ...
+	var digits strings.Builder
+	for _, c := range iban[4:] + iban[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case c >= 'A' && c <= 'Z':
+			digits.WriteString(strconv.Itoa(int(c-'A') + 10))
+		default:
+			return "", newError(ErrorValidation, "invalid IBAN '%s'", iban)
+		}
+	}
+
+	n, _ := new(big.Int).SetString(digits.String(), 10)
+	if n.Mod(n, big.NewInt(97)).Int64() != 1 {
+		return "", newError(ErrorValidation, "invalid IBAN '%s': wrong check digits", iban)
+	}
+	return iban, nil
+}
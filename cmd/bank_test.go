@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"github.com/jktr/go-strichliste/schema"
+	"testing"
+)
+
+func TestNormalizeIBAN(t *testing.T) {
+	tests := []struct {
+		iban string
+		want string // empty if invalid
+	}{
+		{"DE02 1203 0000 0000 2020 51", "DE02120300000000202051"},
+		{"de02120300000000202051", "DE02120300000000202051"},
+		{"GB33 BUKB 2020 1555 5555 55", "GB33BUKB20201555555555"},
+		{"AT61 1904 3002 3457 3201", "AT611904300234573201"},
+		{"DE02 1203 0000 0000 2020 52", ""}, // wrong check digits
+		{"DE03 1203 0000 0000 2020 51", ""},
+		{"DE02-1203-0000-0000-2020-51", ""},
+		{"DE02 1203", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		got, err := normalizeIBAN(test.iban)
+		switch {
+		case test.want == "" && err == nil:
+			t.Errorf("normalizeIBAN(%q) = %q, want an error", test.iban, got)
+		case test.want != "" && (err != nil || got != test.want):
+			t.Errorf("normalizeIBAN(%q) = %q, %v; want %q", test.iban, got, err, test.want)
+		}
+	}
+}
+
+func TestMatchReference(t *testing.T) {
+	bank := &bankConfig{ReferencePrefix: "SL"}
+
+	tests := []struct {
+		reference string
+		id        int // 0 if none
+	}{
+		{"SL-42 alice", 42},
+		{"sl-42 alice", 42},
+		{"SL42 alice", 42},
+		{"SL 42 alice", 42},
+		{"Einzahlung SL-42 alice", 42},
+		{"alice/SL-42", 42},
+		{"SVWZ+SL-7 bob EREF+NOTPROVIDED", 7},
+		{"SLX-42 alice", 0},
+		{"TESL-42 alice", 0},
+		{"SL-42a", 0},
+		{"alice", 0},
+	}
+
+	for _, test := range tests {
+		id, ok := bank.matchReference(test.reference)
+		if ok != (test.id != 0) || id != test.id {
+			t.Errorf("matchReference(%q) = %d, %v; want %d", test.reference, id, ok, test.id)
+		}
+	}
+}
+
+func TestNamesUser(t *testing.T) {
+	bank := &bankConfig{ReferencePrefix: "SL"}
+	alice := &schema.User{ID: 42, Name: "alice"}
+	anne := &schema.User{ID: 43, Name: "Anne-Marie Müller"}
+
+	tests := []struct {
+		reference string
+		user      *schema.User
+		want      bool
+	}{
+		{"SL-42 alice", alice, true},
+		{"SL-42 ALICE", alice, true},
+		{"SL-42 ali ce", alice, true}, // broken into lines
+		{"SL-42", alice, false},
+		{"SL-42 bob", alice, false},
+		{"SL-43 anne-marie müller", anne, true},
+		{"SL-43 Anne Marie Muller", anne, false},
+	}
+
+	for _, test := range tests {
+		if got := bank.namesUser(test.reference, test.user); got != test.want {
+			t.Errorf("namesUser(%q, %q) = %v, want %v", test.reference, test.user.Name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jktr/go-strichliste/schema"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// A chat message addressed to the bot.
+type botMessage struct {
+	Nick    string
+	Account string // as verified by the chat network; empty if not logged in
+	Channel string // empty for private messages
+	Text    string
+}
+
+// Connects the bot to a chat network.
+type botTransport interface {
+	// Blocks until the next message arrives; returns io.EOF once
+	// the transport is closed.
+	Receive() (*botMessage, error)
+
+	// Answers a message, where it came from.
+	Reply(msg *botMessage, text string) error
+
+	Close() error
+}
+
+type bot struct {
+	cli       *CLI
+	transport botTransport
+	prefix    string
+	accounts  map[string]string // chat account -> strichliste user
+}
+
+// Chat commands, by name. Handlers get the user mapped to the
+// sender's account and the command's arguments.
+var botCommands = map[string]struct {
+	usage   string
+	handler func(b *bot, user string, args []string) (string, error)
+}{
+	"balance":  {"!balance", (*bot).balance},
+	"buy":      {"!buy <article> [count]", (*bot).buy},
+	"deposit":  {"!deposit <amount>", (*bot).deposit},
+	"withdraw": {"!withdraw <amount>", (*bot).withdraw},
+	"send":     {"!send <user> <amount>", (*bot).send},
+}
+
+func newBotCommand(cli *CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bot",
+		Short: "answer commands like !buy or !balance in a chat",
+		Long: `answer commands like !buy or !balance in a chat
+
+Chat accounts are mapped to strichliste users by a mapping file with
+one "account user" pair per line. As anyone can take a nick, senders
+are identified by the account they're logged in to (e.g. with
+NickServ), which the IRC server must report with the account-tag
+capability. Messages from senders that aren't logged in, or whose
+accounts aren't mapped, are ignored.
+
+The IRC transport is configured in the config file:
+
+  "bot": {
+    "mapping": "/etc/strichliste-cli/accounts",
+    "irc": {
+      "server": "ircs://irc.example.org:6697",
+      "nick": "strichliste",
+      "password": "",
+      "channels": ["#hackerspace"]
+    }
+  }
+
+The stdio transport reads "nick: message" lines from stdin and
+writes replies to stdout, which is useful for testing; it takes
+nicks as accounts.`,
+		Args: cobra.NoArgs,
+		RunE: cli.wrap(runBot),
+	}
+
+	cmd.Flags().String("transport", "irc", "chat transport to use (irc|stdio)")
+	cmd.Flags().String("mapping", "", "file mapping chat accounts to users")
+	cli.Viper.BindPFlag("bot.mapping", cmd.Flags().Lookup("mapping"))
+
+	cmd.Flags().String("prefix", "!", "prefix of chat commands")
+	cli.Viper.BindPFlag("bot.prefix", cmd.Flags().Lookup("prefix"))
+
+	return cmd
+}
+
+func runBot(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	mapping := cli.Viper.GetString("bot.mapping")
+	if mapping == "" {
+		return newError(ErrorUsage, "no account mapping file configured")
+	}
+
+	accounts, err := loadAccountMapping(mapping)
+	if err != nil {
+		return err
+	}
+
+	var transport botTransport
+	switch name, _ := cmd.Flags().GetString("transport"); name {
+	case "irc":
+		transport, err = dialIRC(&ircOptions{
+			Server:   cli.Viper.GetString("bot.irc.server"),
+			Nick:     cli.Viper.GetString("bot.irc.nick"),
+			Password: cli.Viper.GetString("bot.irc.password"),
+			Channels: cli.Viper.GetStringSlice("bot.irc.channels"),
+		})
+	case "stdio":
+		transport = newStdioTransport(os.Stdin, os.Stdout)
+	default:
+		return newError(ErrorUsage, "unknown transport '%s'", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	b := &bot{
+		cli:       cli,
+		transport: transport,
+		prefix:    cli.Viper.GetString("bot.prefix"),
+		accounts:  accounts,
+	}
+
+	// there's nobody to ask when things are ambiguous,
+	// nor to notice when a close match was the wrong one
+	cli.noPrompt = true
+	cli.exactMatch = true
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		transport.Close()
+	}()
+
+	for {
+		msg, err := transport.Receive()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		reply := b.handle(msg)
+		if reply != "" {
+			transport.Reply(msg, reply)
+		}
+	}
+}
+
+// Runs a chat command; returns the reply, if any.
+func (b *bot) handle(msg *botMessage) string {
+
+	if !strings.HasPrefix(msg.Text, b.prefix) {
+		return ""
+	}
+
+	words := strings.Fields(strings.TrimPrefix(msg.Text, b.prefix))
+	if len(words) == 0 {
+		return ""
+	}
+
+	if words[0] == "help" {
+		var usages []string
+		for _, name := range []string{"balance", "buy", "deposit", "withdraw", "send"} {
+			usages = append(usages, botCommands[name].usage)
+		}
+		return "commands: " + strings.Join(usages, ", ")
+	}
+
+	command, ok := botCommands[words[0]]
+	if !ok {
+		return "" // probably meant for another bot
+	}
+
+	// nicks can be taken by anyone, so only verified accounts count
+	user, ok := b.accounts[strings.ToLower(msg.Account)]
+	if msg.Account == "" || !ok {
+		return ""
+	}
+
+	reply, err := command.handler(b, user, words[1:])
+	if err != nil {
+		if classifyError(err).Kind == ErrorUsage {
+			return fmt.Sprintf("%s: usage: %s", msg.Nick, command.usage)
+		}
+		return fmt.Sprintf("%s: error: %s", msg.Nick, err)
+	}
+	return fmt.Sprintf("%s: %s", msg.Nick, reply)
+}
+
+func (b *bot) balance(username string, args []string) (string, error) {
+
+	if len(args) != 0 {
+		return "", newError(ErrorUsage, "too many arguments")
+	}
+
+	user, err := resolveUser(b.cli, username)
+	if err != nil {
+		return "", err
+	}
+
+	settings, err := b.cli.Settings()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("balance of %s: %s", user.Name, formatCurrency(settings, user.Balance)), nil
+}
+
+func (b *bot) buy(username string, args []string) (string, error) {
+
+	if len(args) == 0 {
+		return "", newError(ErrorUsage, "no article given")
+	}
+
+	// "!buy club mate 2" buys two of "club mate"
+	count := 1
+	if n, err := strconv.Atoi(args[len(args)-1]); err == nil && len(args) > 1 {
+		count, args = n, args[:len(args)-1]
+	}
+
+	user, err := resolveUser(b.cli, username)
+	if err != nil {
+		return "", err
+	}
+
+	article, err := resolveArticle(b.cli, strings.Join(args, " "))
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := purchase(b.cli, user, article, count, "")
+	if err != nil {
+		return "", err
+	}
+	return b.describe(tx)
+}
+
+func (b *bot) deposit(username string, args []string) (string, error) {
+	return b.delta(username, args, 1)
+}
+
+func (b *bot) withdraw(username string, args []string) (string, error) {
+	return b.delta(username, args, -1)
+}
+
+func (b *bot) delta(username string, args []string, sign int) (string, error) {
+
+	if len(args) != 1 {
+		return "", newError(ErrorUsage, "expected an amount")
+	}
+
+	amount, err := parseChatAmount(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	user, err := resolveUser(b.cli, username)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := createDelta(b.cli, user, sign*amount, "")
+	if err != nil {
+		return "", err
+	}
+	return b.describe(tx)
+}
+
+func (b *bot) send(username string, args []string) (string, error) {
+
+	if len(args) != 2 {
+		return "", newError(ErrorUsage, "expected a user and an amount")
+	}
+
+	amount, err := parseChatAmount(args[1])
+	if err != nil {
+		return "", err
+	}
+
+	from, err := resolveUser(b.cli, username)
+	if err != nil {
+		return "", err
+	}
+
+	to, err := resolveUser(b.cli, args[0])
+	if err != nil {
+		return "", err
+	}
+
+	if from.ID == to.ID {
+		return "", newError(ErrorValidation, "can't send funds to yourself")
+	}
+
+	// transfers use negative amounts
+	tx, err := createTransfer(b.cli, from, to, -amount, "")
+	if err != nil {
+		return "", err
+	}
+	return b.describe(tx)
+}
+
+// Formats a transaction as a reply, like "1x Mate (tx #42); balance: 3.50€".
+func (b *bot) describe(tx *schema.Transaction) (string, error) {
+
+	settings, err := b.cli.Settings()
+	if err != nil {
+		return "", err
+	}
+
+	what := describeTransaction(tx)
+	if what == "" {
+		what = formatCurrency(settings, tx.Value)
+	}
+	return fmt.Sprintf("%s (tx #%d); balance of %s: %s",
+		what, tx.ID, tx.Issuer.Name, formatCurrency(settings, tx.Issuer.Balance)), nil
+}
+
+// Parses a positive decimal amount; accepts a decimal comma, too.
+func parseChatAmount(s string) (int, error) {
+	f, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil || f <= 0 {
+		return 0, newError(ErrorValidation, "invalid amount '%s'", s)
+	}
+	return CurrencyFloat64ToInt(f), nil
+}
+
+// Reads "account user" pairs, one per line; "#" starts a comment.
+func loadAccountMapping(path string) (map[string]string, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	accounts := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+			continue
+		case 2:
+			// IRC accounts are case-insensitive
+			accounts[strings.ToLower(fields[0])] = fields[1]
+		default:
+			return nil, newError(ErrorUsage, "%s:%d: expected \"account user\"", path, n)
+		}
+	}
+	return accounts, scanner.Err()
+}
+
+// Reads "nick: message" lines and writes replies as lines.
+type stdioTransport struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+func newStdioTransport(in io.Reader, out io.Writer) *stdioTransport {
+	return &stdioTransport{
+		in:  bufio.NewScanner(in),
+		out: out,
+	}
+}
+
+func (t *stdioTransport) Receive() (*botMessage, error) {
+	for t.in.Scan() {
+		parts := strings.SplitN(t.in.Text(), ":", 2)
+		if len(parts) != 2 {
+			fmt.Fprintln(t.out, `expected "nick: message"`)
+			continue
+		}
+		nick := strings.TrimSpace(parts[0])
+		return &botMessage{
+			Nick:    nick,
+			Account: nick,
+			Text:    strings.TrimSpace(parts[1]),
+		}, nil
+	}
+
+	if err := t.in.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (t *stdioTransport) Reply(msg *botMessage, text string) error {
+	_, err := fmt.Fprintln(t.out, text)
+	return err
+}
+
+func (t *stdioTransport) Close() error {
+	return nil
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/jktr/go-strichliste/schema"
 	"github.com/spf13/cobra"
 )
 
@@ -10,16 +11,19 @@ func newBuyCommand(cli *CLI) *cobra.Command {
 		Use:   "buy",
 		Short: "buy some amount of an article",
 		Args:  cobra.NoArgs,
-		RunE:  cli.wrap(runBuy),
+		RunE:  cli.wrap(runPreHooks, runBuy, printReceipt, runPostHooks),
 	}
 
-	cmd.Flags().IntP("article", "a", 0, "id of article to buy")
+	cmd.Flags().StringP("article", "a", "", "ID, barcode or name of article to buy")
 	cmd.MarkFlagRequired("article")
+	cmd.RegisterFlagCompletionFunc("article", cli.wrapCompletion(completeArticleNames))
 
 	cmd.Flags().IntP("count", "c", 1, "amount to buy")
 
 	cmd.Flags().String("comment", "", "add comment to transaction")
 
+	addReceiptFlags(cmd)
+
 	return cmd
 
 }
@@ -27,30 +31,31 @@ func newBuyCommand(cli *CLI) *cobra.Command {
 func runBuy(cli *CLI, cmd *cobra.Command, args []string) error {
 
 	comment, _ := cmd.Flags().GetString("comment")
-	articleId, _ := cmd.Flags().GetInt("article")
+	query, _ := cmd.Flags().GetString("article")
 	count, _ := cmd.Flags().GetInt("count")
 	username, _ := cmd.Flags().GetString("user")
 
-	if count <= 0 {
-		return fmt.Errorf("must buy at least one instance of the article\n")
+	user, err := resolveUser(cli, username)
+	if err != nil {
+		return err
 	}
 
-	user, _, err := cli.Client.User.GetByName(username)
+	settings, err := cli.Settings()
 	if err != nil {
 		return err
 	}
 
-	settings, _, err := cli.Client.Settings.Get()
+	article, err := resolveArticle(cli, query)
 	if err != nil {
 		return err
 	}
 
-	tx, _, err := cli.Client.Transaction.Context(user.ID).
-		WithComment(comment).Purchase(articleId, count)
+	tx, err := purchase(cli, user, article, count, comment)
 	if err != nil {
 		return err
 	}
 
+	cli.result = tx
 	fmt.Printf("created transaction #%d\n", tx.ID)
 	fmt.Printf("new balance for user #%d (%s): %.2f%s\n",
 		tx.Issuer.ID,
@@ -60,3 +65,28 @@ func runBuy(cli *CLI, cmd *cobra.Command, args []string) error {
 	)
 	return nil
 }
+
+// Validates and creates the purchase of count instances of an article.
+func purchase(cli *CLI, user *schema.User, article *schema.Article, count int, comment string) (*schema.Transaction, error) {
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return nil, err
+	}
+
+	err = validatePurchase(settings, user, article, count)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, _, err := cli.Client.Transaction.Context(user.ID).
+		WithComment(comment).Purchase(article.ID, count)
+	if err != nil {
+		return nil, err
+	}
+
+	cli.decrementStock(article, count)
+	cli.notifyLowBalance(tx)
+	cli.deliverWebhooks(tx)
+	return tx, nil
+}
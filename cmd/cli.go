@@ -1,16 +1,30 @@
 package cmd
 
 import (
+	"fmt"
 	s "github.com/jktr/go-strichliste"
+	"github.com/jktr/go-strichliste/schema"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
 )
 
 type CLI struct {
 	RootCommand *cobra.Command
 	Viper       *viper.Viper
 	Client      *s.Client
+
+	settings   *schema.Settings // cached; see Settings
+	settingsMu sync.Mutex       // serve handles requests concurrently
+	noPrompt   bool             // never ask the user to choose; see chooseCandidate
+	exactMatch bool             // only accept exact names, ignoring case; see chooseCandidate
+	result     interface{}      // what a mutating command changed; see runPostHooks
+	deliveries sync.WaitGroup   // webhooks in flight; see deliverWebhooks
 }
 
 func NewCLI() *CLI {
@@ -33,6 +47,121 @@ func (c *CLI) wrap(fs ...func(*CLI, *cobra.Command, []string) error) func(*cobra
 	}
 }
 
+// Executes the command line and reports any error; returns the exit code.
+func (c *CLI) Execute() int {
+	cmd, err := c.RootCommand.ExecuteC()
+	c.deliveries.Wait()
+	if err != nil {
+		return c.reportError(cmd, err)
+	}
+	return 0
+}
+
+// Retrieves the server's settings. These rarely change, so they're
+// only fetched once and reused for the lifetime of the CLI.
+func (c *CLI) Settings() (*schema.Settings, error) {
+	c.settingsMu.Lock()
+	defer c.settingsMu.Unlock()
+
+	if c.settings != nil {
+		return c.settings, nil
+	}
+
+	settings, _, err := c.Client.Settings.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	c.settings = settings
+	return settings, nil
+}
+
+// Returns the path of a file that persists local state (history,
+// caches, etc.) and creates its parent directory if necessary.
+func statePath(name string) (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir != "" {
+		dir = filepath.Join(dir, "strichliste-cli")
+	} else {
+		dir = filepath.Join(os.Getenv("HOME"), ".strichliste-cli")
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// Locks a state file against concurrent updates, e.g. by the gateway
+// and a command run from the shell; call the returned function to
+// unlock it.
+func lockState(name string) (func(), error) {
+
+	path, err := statePath(name + ".lock")
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+// Number of entries to request per page when listing everything.
+const listPageSize = 100
+
+// Returns a writer that aligns tab-separated columns; call Flush.
+func newTableWriter() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+}
+
+// Formats a point in time for tables; zero times are left blank.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(schema.TimestampLayout)
+}
+
+// A field in a before/after comparison, with formatted values.
+type fieldChange struct {
+	name, before, after string
+}
+
+// Prints the fields that differ below a header;
+// returns whether there were any.
+func printChanges(header string, changes []fieldChange) bool {
+	changed := false
+	for _, c := range changes {
+		if c.before == c.after {
+			continue
+		}
+		if !changed {
+			fmt.Println(header)
+			changed = true
+		}
+		fmt.Printf("\t%s: %s -> %s\n", c.name, c.before, c.after)
+	}
+	return changed
+}
+
+// Quotes a value for display, showing empty ones as such.
+func quoteValue(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return "'" + value + "'"
+}
+
 func CurrencyIntToFloat64(balance int) float64 {
 	return float64(balance) / 100
 }
@@ -40,3 +169,8 @@ func CurrencyIntToFloat64(balance int) float64 {
 func CurrencyFloat64ToInt(balance float64) int {
 	return int(math.Round(balance * 100))
 }
+
+// Formats an amount in the server's currency, e.g. "1.50€".
+func formatCurrency(settings *schema.Settings, amount int) string {
+	return fmt.Sprintf("%.2f%s", CurrencyIntToFloat64(amount), settings.I18n.Currency.Symbol)
+}
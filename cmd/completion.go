@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	s "github.com/jktr/go-strichliste"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Completions are cached for a short while, as every press of <tab>
+// runs a new process, which would otherwise query the API each time.
+const completionCacheTTL = 30 * time.Second
+
+type completionCacheEntry struct {
+	Fetched     time.Time `json:"fetched"`
+	Completions []string  `json:"completions"`
+}
+
+func newCompletionCommand(cli *CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion bash|zsh|fish",
+		Short: "generate a shell completion script",
+		Long: `generate a shell completion script
+
+To load completions for the current session, run e.g.
+
+  bash: source <(strichliste-cli completion bash)
+  zsh:  source <(strichliste-cli completion zsh)
+  fish: strichliste-cli completion fish | source
+
+Usernames, articles and transactions are queried from the API
+and cached for a short while.`,
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish"},
+		RunE:      cli.wrap(runCompletion),
+	}
+}
+
+func runCompletion(cli *CLI, cmd *cobra.Command, args []string) error {
+	switch args[0] {
+	case "bash":
+		return cli.RootCommand.GenBashCompletionV2(os.Stdout, true)
+	case "zsh":
+		return cli.RootCommand.GenZshCompletion(os.Stdout)
+	case "fish":
+		return cli.RootCommand.GenFishCompletion(os.Stdout, true)
+	}
+	return fmt.Errorf("unsupported shell '%s'", args[0])
+}
+
+// Adapts a completion function to cobra's signature. This also
+// (re)initializes the client, as the flags of the command line being
+// completed are only parsed after the regular setup ran.
+func (c *CLI) wrapCompletion(f func(*CLI, *cobra.Command, []string) ([]string, error)) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		c.Client = nil
+		for _, init := range []func(*CLI, *cobra.Command, []string) error{initConfig, initClient} {
+			if err := init(c, cmd, args); err != nil {
+				return nil, cobra.ShellCompDirectiveError
+			}
+		}
+
+		completions, err := f(c, cmd, args)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		return completions, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// Only completes the first positional argument.
+func firstArg(f func(*CLI, *cobra.Command, []string) ([]string, error)) func(*CLI, *cobra.Command, []string) ([]string, error) {
+	return func(cli *CLI, cmd *cobra.Command, args []string) ([]string, error) {
+		if len(args) > 0 {
+			return nil, nil
+		}
+		return f(cli, cmd, args)
+	}
+}
+
+// Usernames, described by their ID.
+func completeUserNames(cli *CLI, cmd *cobra.Command, args []string) ([]string, error) {
+	return userCompletions(cli, "user-names", "%[2]s\t#%03[1]d")
+}
+
+// User IDs, described by their name.
+func completeUserIDs(cli *CLI, cmd *cobra.Command, args []string) ([]string, error) {
+	return userCompletions(cli, "user-ids", "%[1]d\t%[2]s")
+}
+
+func userCompletions(cli *CLI, key, format string) ([]string, error) {
+	return cli.cachedCompletions(key, func() ([]string, error) {
+		users, _, err := cli.Client.User.List(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var completions []string
+		for _, user := range users {
+			if user.IsActive {
+				completions = append(completions,
+					fmt.Sprintf(format, user.ID, user.Name))
+			}
+		}
+		return completions, nil
+	})
+}
+
+// Article IDs, described by their name and value.
+func completeArticleIDs(cli *CLI, cmd *cobra.Command, args []string) ([]string, error) {
+	return articleCompletions(cli, "article-ids", "%[1]d\t%[2]s (%.2[3]f)")
+}
+
+// Article names, described by their ID and value.
+func completeArticleNames(cli *CLI, cmd *cobra.Command, args []string) ([]string, error) {
+	return articleCompletions(cli, "article-names", "%[2]s\t#%03[1]d (%.2[3]f)")
+}
+
+func articleCompletions(cli *CLI, key, format string) ([]string, error) {
+	return cli.cachedCompletions(key, func() ([]string, error) {
+		articles, _, err := cli.Client.Article.List(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var completions []string
+		for _, article := range articles {
+			if article.IsActive {
+				completions = append(completions, fmt.Sprintf(format,
+					article.ID, article.Name, CurrencyIntToFloat64(article.Value)))
+			}
+		}
+		return completions, nil
+	})
+}
+
+// The current user's recent transactions that can still be reversed.
+func completeTransactionIDs(cli *CLI, cmd *cobra.Command, args []string) ([]string, error) {
+
+	username, _ := cmd.Flags().GetString("user")
+
+	return cli.cachedCompletions("transactions/"+username, func() ([]string, error) {
+		user, _, err := cli.Client.User.GetByName(username)
+		if err != nil {
+			return nil, err
+		}
+
+		txs, _, err := cli.Client.Transaction.Context(user.ID).List(&s.ListOpts{PerPage: 10})
+		if err != nil {
+			return nil, err
+		}
+
+		var completions []string
+		for _, tx := range txs {
+			if !tx.IsReversible || tx.IsReversed {
+				continue
+			}
+
+			completions = append(completions, strings.TrimSpace(fmt.Sprintf("%d\t%+.2f %s",
+				tx.ID, CurrencyIntToFloat64(tx.Value), describeTransaction(&tx))))
+		}
+		return completions, nil
+	})
+}
+
+// Strips the descriptions off completions.
+func completionValues(completions []string) []string {
+	var values []string
+	for _, c := range completions {
+		values = append(values, strings.SplitN(c, "\t", 2)[0])
+	}
+	return values
+}
+
+// Returns the completions stored under key, calling fetch if they are
+// missing or stale. Caching is best-effort; failures are ignored.
+func (c *CLI) cachedCompletions(key string, fetch func() ([]string, error)) ([]string, error) {
+
+	// completions differ between instances
+	key = c.Viper.GetString("api-url") + " " + key
+
+	cache := map[string]completionCacheEntry{}
+
+	path, err := statePath("completions.json")
+	if err == nil {
+		if buf, err := ioutil.ReadFile(path); err == nil {
+			json.Unmarshal(buf, &cache)
+		}
+	}
+
+	entry, ok := cache[key]
+	if ok && time.Since(entry.Fetched) < completionCacheTTL {
+		return entry.Completions, nil
+	}
+
+	completions, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	// drop stale entries so the cache doesn't grow unbounded
+	for k, v := range cache {
+		if time.Since(v.Fetched) >= completionCacheTTL {
+			delete(cache, k)
+		}
+	}
+	cache[key] = completionCacheEntry{
+		Fetched:     time.Now(),
+		Completions: completions,
+	}
+
+	if path != "" {
+		if buf, err := json.Marshal(cache); err == nil {
+			ioutil.WriteFile(path, buf, 0600)
+		}
+	}
+	return completions, nil
+}
+
+// Discards all cached completions, e.g. after names were changed.
+func invalidateCompletions() {
+	if path, err := statePath("completions.json"); err == nil {
+		os.Remove(path)
+	}
+}
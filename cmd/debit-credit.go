@@ -12,17 +12,21 @@ func newDebitCommand(cli *CLI) *cobra.Command {
 		Aliases: []string{"withdraw"},
 		Short:   "deduct from an account's balance",
 		Args:    cobra.NoArgs,
-		RunE:    cli.wrap(runTransact),
+		RunE:    cli.wrap(runPreHooks, runTransact, printReceipt, runPostHooks),
 	}
 
 	cmd.Flags().String("from", "", "account to debit (prefer --user)")
 	cmd.Flags().String("to", "", "account to credit (if any)")
+	cmd.RegisterFlagCompletionFunc("from", cli.wrapCompletion(completeUserNames))
+	cmd.RegisterFlagCompletionFunc("to", cli.wrapCompletion(completeUserNames))
 
 	cmd.Flags().StringP("comment", "c", "", "add comment to transaction")
 
 	cmd.Flags().Float64P("amount", "a", 0, "amount to withdraw, as a decimal")
 	cmd.MarkFlagRequired("amount")
 
+	addReceiptFlags(cmd)
+
 	return cmd
 
 }
@@ -32,17 +36,23 @@ func newCreditCommand(cli *CLI) *cobra.Command {
 		Aliases: []string{"deposit"},
 		Short:   "add to an account's balance",
 		Args:    cobra.NoArgs,
-		RunE:    cli.wrap(runTransact),
+		RunE:    cli.wrap(runPreHooks, runTransact, printReceipt, runPostHooks),
 	}
 
 	cmd.Flags().String("from", "", "account to debit (if any)")
 	cmd.Flags().String("to", "", "account to credit (prefer --user)")
+	cmd.RegisterFlagCompletionFunc("from", cli.wrapCompletion(completeUserNames))
+	cmd.RegisterFlagCompletionFunc("to", cli.wrapCompletion(completeUserNames))
 
 	cmd.Flags().StringP("comment", "c", "", "add comment to transaction")
 
 	cmd.Flags().Float64P("amount", "a", 1.00, "amount to deposit, as a decimal")
 	cmd.MarkFlagRequired("amount")
 
+	cmd.Flags().String("via", "", "pay the deposit via paypal, showing a payment link")
+
+	addReceiptFlags(cmd)
+
 	return cmd
 
 }
@@ -104,13 +114,13 @@ func runTransact(cli *CLI, cmd *cobra.Command, args []string) error {
 	floatAmount, _ := cmd.Flags().GetFloat64("amount")
 	amount := CurrencyFloat64ToInt(floatAmount)
 	if amount == 0 {
-		return fmt.Errorf("amount most not be zero\n")
+		return newError(ErrorValidation, "amount must not be zero")
 	}
 
 	src, dst := resolveSrcDst(cli, cmd)
 
 	if src == dst {
-		return fmt.Errorf("source and destination must be different when sending funds\n")
+		return newError(ErrorValidation, "source and destination must be different when sending funds")
 	}
 
 	// XXX User-to-User transaction are forced to use negative amounts
@@ -119,23 +129,36 @@ func runTransact(cli *CLI, cmd *cobra.Command, args []string) error {
 		amount = -amount
 	}
 
+	// only the credit command has --via
+	via, _ := cmd.Flags().GetString("via")
+	if via != "" && via != "paypal" {
+		return newError(ErrorUsage, "unknown payment method '%s'", via)
+	}
+	if via != "" && src != "" {
+		return newError(ErrorUsage, "--via only works for deposits, not transfers")
+	}
+
 	var srcUser, dstUser *schema.User
 	var err error
 
 	if src != "" {
-		srcUser, _, err = cli.Client.User.GetByName(src)
+		srcUser, err = resolveUser(cli, src)
 		if err != nil {
 			return err
 		}
 
 	}
 	if dst != "" {
-		dstUser, _, err = cli.Client.User.GetByName(dst)
+		dstUser, err = resolveUser(cli, dst)
 		if err != nil {
 			return err
 		}
 	}
 
+	if via == "paypal" {
+		return transactPaypal(cli, dstUser, amount, comment)
+	}
+
 	if src == "" {
 		return transactDelta(cli, dstUser, amount, comment)
 	}
@@ -149,17 +172,17 @@ func runTransact(cli *CLI, cmd *cobra.Command, args []string) error {
 
 func transactDelta(cli *CLI, from *schema.User, amount int, comment string) error {
 
-	settings, _, err := cli.Client.Settings.Get()
+	settings, err := cli.Settings()
 	if err != nil {
 		return err
 	}
 
-	tx, _, err := cli.Client.Transaction.Context(from.ID).
-		WithComment(comment).Delta(amount)
+	tx, err := createDelta(cli, from, amount, comment)
 	if err != nil {
 		return err
 	}
 
+	cli.result = tx
 	fmt.Printf("created transaction #%d\n", tx.ID)
 	fmt.Printf("new balance for user #%d (%s): %.2f%s\n",
 		tx.Issuer.ID,
@@ -170,19 +193,43 @@ func transactDelta(cli *CLI, from *schema.User, amount int, comment string) erro
 	return nil
 }
 
+// Validates and creates a deposit (positive amount) or withdrawal.
+func createDelta(cli *CLI, user *schema.User, amount int, comment string) (*schema.Transaction, error) {
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateDelta(settings, user, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, _, err := cli.Client.Transaction.Context(user.ID).
+		WithComment(comment).Delta(amount)
+	if err != nil {
+		return nil, err
+	}
+
+	cli.notifyLowBalance(tx)
+	cli.deliverWebhooks(tx)
+	return tx, nil
+}
+
 func transactSend(cli *CLI, from, to *schema.User, amount int, comment string) error {
 
-	settings, _, err := cli.Client.Settings.Get()
+	settings, err := cli.Settings()
 	if err != nil {
 		return err
 	}
 
-	tx, _, err := cli.Client.Transaction.Context(from.ID).
-		WithComment(comment).TransferFunds(to.ID, amount)
+	tx, err := createTransfer(cli, from, to, amount, comment)
 	if err != nil {
 		return err
 	}
 
+	cli.result = tx
 	fmt.Printf("created transaction #%d\n", tx.ID)
 	fmt.Printf("new balance for user #%d (%s): %.2f%s\n",
 		tx.Issuer.ID,
@@ -199,3 +246,27 @@ func transactSend(cli *CLI, from, to *schema.User, amount int, comment string) e
 
 	return nil
 }
+
+// Validates and creates a transfer; amount is negative, as sent to the server.
+func createTransfer(cli *CLI, from, to *schema.User, amount int, comment string) (*schema.Transaction, error) {
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateTransfer(settings, from, to, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, _, err := cli.Client.Transaction.Context(from.ID).
+		WithComment(comment).TransferFunds(to.ID, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	cli.notifyLowBalance(tx)
+	cli.deliverWebhooks(tx)
+	return tx, nil
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/boombuler/barcode/qr"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+func newDepositQRCommand(cli *CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deposit-qr [user]",
+		Short: "show a QR code for depositing via bank transfer (default: your own account)",
+		Long: `show a QR code for depositing via bank transfer (default: your own account)
+
+The QR code is an EPC QR code ("GiroCode"), which most banking apps
+can scan, for a transfer to the account configured under "bank" in
+the config file, with a reference identifying the user; see
+"import bank" for booking the deposits.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: cli.wrap(runDepositQR),
+
+		ValidArgsFunction: cli.wrapCompletion(firstArg(completeUserNames)),
+	}
+
+	cmd.Flags().Float64P("amount", "a", 0, "amount to deposit, as a decimal (default: let the payer choose)")
+	cmd.Flags().String("png", "", "write the QR code to this PNG file instead")
+
+	return cmd
+}
+
+func runDepositQR(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	username, _ := cmd.Flags().GetString("user")
+	if len(args) > 0 {
+		username = args[0]
+	}
+
+	floatAmount, _ := cmd.Flags().GetFloat64("amount")
+	amount := CurrencyFloat64ToInt(floatAmount)
+	if amount < 0 {
+		return newError(ErrorValidation, "amount must be positive")
+	}
+
+	bank, err := loadBankConfig(cli)
+	if err != nil {
+		return err
+	}
+	if bank.IBAN == "" || bank.Name == "" {
+		return newError(ErrorUsage, "bank.iban and bank.name must be configured")
+	}
+
+	iban, err := normalizeIBAN(bank.IBAN)
+	if err != nil {
+		return err
+	}
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return err
+	}
+
+	// SEPA credit transfers are in euros only
+	if settings.I18n.Currency.Alpha3 != "EUR" {
+		return newError(ErrorValidation, "EPC QR codes only support EUR, not %s",
+			settings.I18n.Currency.Alpha3)
+	}
+
+	user, err := resolveUser(cli, username)
+	if err != nil {
+		return err
+	}
+
+	if amount > 0 {
+		err = validateDelta(settings, user, amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	reference := bank.reference(user)
+	payload := epcPayload(bank.BIC, bank.Name, iban, amount, reference)
+
+	// EPC069-12 mandates error correction level M
+	code, err := qr.Encode(payload, qr.M, qr.Unicode)
+	if err != nil {
+		return err
+	}
+
+	png, _ := cmd.Flags().GetString("png")
+	if png != "" {
+		buf, err := renderBarcodePNG(code)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(png, buf, 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", png)
+		return nil
+	}
+
+	fmt.Printf("beneficiary: %s\n", bank.Name)
+	fmt.Printf("IBAN: %s\n", iban)
+	if bank.BIC != "" {
+		fmt.Printf("BIC: %s\n", bank.BIC)
+	}
+	if amount > 0 {
+		fmt.Printf("amount: %s\n", formatCurrency(settings, amount))
+	}
+	fmt.Printf("reference: %s\n\n", reference)
+	printTerminalQR(code)
+	return nil
+}
+
+// Formats an EPC069-12 payload, version 002, which makes the BIC
+// optional within the EEA. An amount of 0 is left for the payer to fill in.
+func epcPayload(bic, name, iban string, amount int, reference string) string {
+
+	value := ""
+	if amount > 0 {
+		value = fmt.Sprintf("EUR%.2f", CurrencyIntToFloat64(amount))
+	}
+
+	lines := []string{
+		"BCD",
+		"002",
+		"1", // UTF-8
+		"SCT",
+		bic,
+		truncateRunes(name, 70),
+		iban,
+		value,
+		"", // purpose
+		"", // structured reference
+		truncateRunes(reference, 140),
+	}
+	return strings.Join(lines, "\n")
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
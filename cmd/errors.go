@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jktr/go-strichliste/schema"
+	"github.com/spf13/cobra"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Classifies errors, so that scripts can branch on the exit code.
+type ErrorKind string
+
+const (
+	ErrorGeneric    ErrorKind = "error"
+	ErrorUsage      ErrorKind = "usage"
+	ErrorNotFound   ErrorKind = "not-found"
+	ErrorAmbiguous  ErrorKind = "ambiguous"
+	ErrorValidation ErrorKind = "validation"
+	ErrorLimit      ErrorKind = "limit"
+	ErrorNetwork    ErrorKind = "network"
+	ErrorServer     ErrorKind = "server"
+	ErrorDryRun     ErrorKind = "dry-run"
+)
+
+// Exit codes by kind of error. Keep these in sync with the
+// root command's help text and the README.
+var exitCodes = map[ErrorKind]int{
+	ErrorGeneric:    1,
+	ErrorUsage:      2,
+	ErrorNotFound:   3,
+	ErrorAmbiguous:  4,
+	ErrorValidation: 5,
+	ErrorLimit:      6,
+	ErrorNetwork:    7,
+	ErrorServer:     8,
+	ErrorDryRun:     9,
+}
+
+const exitCodeHelp = `Exit codes:
+  0  success
+  1  unclassified error
+  2  invalid usage (flags or arguments)
+  3  user, article or transaction not found
+  4  ambiguous user or article
+  5  validation failed (e.g. disabled feature, invalid amount)
+  6  transaction or account limit exceeded
+  7  network error (server unreachable, timeout)
+  8  server error
+  9  dry-run; nothing was changed`
+
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) ExitCode() int {
+	return exitCodes[e.Kind]
+}
+
+func newError(kind ErrorKind, format string, args ...interface{}) error {
+	return &Error{
+		Kind: kind,
+		Err:  fmt.Errorf(format, args...),
+	}
+}
+
+// Derives an error's kind, including ones returned by the API client.
+func classifyError(err error) *Error {
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var apiErr *schema.ErrorResponse
+	if errors.As(err, &apiErr) {
+		switch apiErr.Class {
+		case schema.ErrorUserNotFound,
+			schema.ErrorArticleNotFound,
+			schema.ErrorTransactionNotFound,
+			schema.ErrorParameterNotFound:
+			return &Error{Kind: ErrorNotFound, Err: err}
+
+		case schema.ErrorAccountBalanceBoundary,
+			schema.ErrorTransactionBoundary:
+			return &Error{Kind: ErrorLimit, Err: err}
+
+		case schema.ErrorParameterInvalid,
+			schema.ErrorParameterMissing,
+			schema.ErrorUserAlreadyExists,
+			schema.ErrorArticleBarcodeAlreadyExists,
+			schema.ErrorArticleInactive,
+			schema.ErrorTransactionNotDeletable:
+			return &Error{Kind: ErrorValidation, Err: err}
+		}
+		return &Error{Kind: ErrorServer, Err: err}
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return &Error{Kind: ErrorNetwork, Err: err}
+	}
+
+	// the client doesn't type errors without a JSON body
+	if strings.Contains(err.Error(), "server responded with status code") {
+		return &Error{Kind: ErrorServer, Err: err}
+	}
+
+	return &Error{Kind: ErrorGeneric, Err: err}
+}
+
+// Prints an error in the configured output format; returns the exit code.
+func (c *CLI) reportError(cmd *cobra.Command, err error) int {
+
+	e := classifyError(err)
+
+	if c.Viper.GetString("output") == "json" {
+		json.NewEncoder(os.Stderr).Encode(map[string]interface{}{
+			"error": map[string]interface{}{
+				"kind":    e.Kind,
+				"code":    e.ExitCode(),
+				"message": e.Error(),
+			},
+		})
+		return e.ExitCode()
+	}
+
+	switch e.Kind {
+	case ErrorDryRun:
+		fmt.Printf("dry-run: %s\n", e)
+	case ErrorUsage:
+		fmt.Fprintf(os.Stderr, "Error: %s\n", e)
+		if cmd != nil {
+			fmt.Fprint(os.Stderr, cmd.UsageString())
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %s\n", e)
+	}
+	return e.ExitCode()
+}
+
+// Marks flag and argument errors as usage errors.
+func markUsageErrors(cmd *cobra.Command) {
+
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return &Error{Kind: ErrorUsage, Err: err}
+	})
+
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(cmd *cobra.Command, a []string) error {
+			if err := args(cmd, a); err != nil {
+				return &Error{Kind: ErrorUsage, Err: err}
+			}
+			return nil
+		}
+	}
+
+	for _, c := range cmd.Commands() {
+		markUsageErrors(c)
+	}
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jktr/go-strichliste/schema"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Hooks are shell commands run before and after mutating commands, as
+// configured under "hooks" in the config file, by command name:
+//
+//	"hooks": {
+//	  "pre": {"buy": ["check-opening-hours"]},
+//	  "post": {"*": ["paplay ka-ching.ogg"], "user-create": ["welcome-mail"]}
+//	}
+//
+// Subcommands are named by their path, e.g. "user-create"; "*" matches
+// all commands. Hooks get a hookEvent as JSON on stdin and its gist in
+// STRICHLISTE_* environment variables. A pre-hook exiting with a non-zero
+// status vetoes the command; post-hooks only run if it changed something.
+// Neither run on dry-runs.
+
+type hookEvent struct {
+	Hook    string            `json:"hook"` // pre or post
+	Command string            `json:"command"`
+	User    string            `json:"user"`
+	Args    []string          `json:"args"`
+	Flags   map[string]string `json:"flags"` // as given on the command line
+	Result  interface{}       `json:"result,omitempty"`
+}
+
+// Runs the pre-hooks of the command; chain it before the command in CLI.wrap.
+func runPreHooks(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	cli.result = nil
+
+	if isDryRun(cmd) {
+		return nil
+	}
+
+	event := newHookEvent("pre", cmd, args)
+	for _, hook := range cli.hooks("pre", event.Command) {
+		err := runHookCommand(hook, event, hookEnv(event))
+		if err != nil {
+			return newError(ErrorValidation, "pre-hook '%s' vetoed %s: %s", hook, event.Command, err)
+		}
+	}
+	return nil
+}
+
+// Runs the post-hooks of the command; chain it after the command in CLI.wrap.
+// The command has already taken effect, so failures are only reported.
+func runPostHooks(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	// e.g. an update that didn't change anything
+	if cli.result == nil {
+		return nil
+	}
+
+	event := newHookEvent("post", cmd, args)
+	event.Result = cli.result
+	for _, hook := range cli.hooks("post", event.Command) {
+		err := runHookCommand(hook, event, hookEnv(event))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: post-hook '%s' failed: %s\n", hook, err)
+		}
+	}
+	return nil
+}
+
+// Whether the command only shows what it would do, as with --dry-run
+// or without --confirm.
+func isDryRun(cmd *cobra.Command) bool {
+	if dryRun, err := cmd.Flags().GetBool("dry-run"); err == nil && dryRun {
+		return true
+	}
+	if confirmed, err := cmd.Flags().GetBool("confirm"); err == nil && !confirmed {
+		return true
+	}
+	return false
+}
+
+func (c *CLI) hooks(kind, command string) []string {
+	return append(
+		c.Viper.GetStringSlice("hooks."+kind+".*"),
+		c.Viper.GetStringSlice("hooks."+kind+"."+command)...,
+	)
+}
+
+func newHookEvent(kind string, cmd *cobra.Command, args []string) *hookEvent {
+
+	// "strichliste-cli user create" -> "user-create"
+	path := strings.Fields(cmd.CommandPath())[1:]
+
+	event := &hookEvent{
+		Hook:    kind,
+		Command: strings.Join(path, "-"),
+		Args:    args,
+		Flags:   map[string]string{},
+	}
+	event.User, _ = cmd.Flags().GetString("user")
+
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		event.Flags[f.Name] = f.Value.String()
+	})
+	return event
+}
+
+func hookEnv(event *hookEvent) []string {
+
+	env := []string{
+		"STRICHLISTE_HOOK=" + event.Hook,
+		"STRICHLISTE_COMMAND=" + event.Command,
+		"STRICHLISTE_USER=" + event.User,
+	}
+
+	switch r := event.Result.(type) {
+	case *schema.Transaction:
+		env = append(env,
+			"STRICHLISTE_TRANSACTION_ID="+strconv.Itoa(r.ID),
+			fmt.Sprintf("STRICHLISTE_AMOUNT=%.2f", CurrencyIntToFloat64(r.Value)),
+			fmt.Sprintf("STRICHLISTE_BALANCE=%.2f", CurrencyIntToFloat64(r.Issuer.Balance)),
+		)
+	case *schema.User:
+		env = append(env,
+			"STRICHLISTE_USER_ID="+strconv.Itoa(r.ID),
+			fmt.Sprintf("STRICHLISTE_BALANCE=%.2f", CurrencyIntToFloat64(r.Balance)),
+		)
+	case *schema.Article:
+		env = append(env,
+			"STRICHLISTE_ARTICLE_ID="+strconv.Itoa(r.ID),
+			fmt.Sprintf("STRICHLISTE_VALUE=%.2f", CurrencyIntToFloat64(r.Value)),
+		)
+
+	// e.g. revert --last; amounts are summed up
+	case []*schema.Transaction:
+		var ids []string
+		amount := 0
+		for _, tx := range r {
+			ids = append(ids, strconv.Itoa(tx.ID))
+			amount += tx.Value
+		}
+		env = append(env,
+			"STRICHLISTE_TRANSACTION_IDS="+strings.Join(ids, ","),
+			fmt.Sprintf("STRICHLISTE_AMOUNT=%.2f", CurrencyIntToFloat64(amount)),
+		)
+		if len(r) == 1 {
+			env = append(env, "STRICHLISTE_TRANSACTION_ID="+strconv.Itoa(r[0].ID))
+		}
+		if len(r) > 0 && sameIssuer(r) {
+			last := r[len(r)-1]
+			env = append(env, fmt.Sprintf("STRICHLISTE_BALANCE=%.2f", CurrencyIntToFloat64(last.Issuer.Balance)))
+		}
+	case []*schema.Article:
+		var ids []string
+		for _, article := range r {
+			ids = append(ids, strconv.Itoa(article.ID))
+		}
+		env = append(env, "STRICHLISTE_ARTICLE_IDS="+strings.Join(ids, ","))
+	}
+	return env
+}
+
+// Whether all transactions are of the same user, whose balance after
+// the last one is thus meaningful.
+func sameIssuer(txs []*schema.Transaction) bool {
+	for _, tx := range txs {
+		if tx.Issuer.ID != txs[0].Issuer.ID {
+			return false
+		}
+	}
+	return true
+}
+
+// Runs a shell command with payload as JSON on stdin. Its output goes
+// to stderr, so as not to interfere with the CLI's own output.
+func runHookCommand(command string, payload interface{}, env []string) error {
+
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(buf)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.Run()
+}
@@ -0,0 +1,529 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/jktr/go-strichliste/schema"
+	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// An incoming payment on a bank statement.
+type bankEntry struct {
+	ID        string // the bank's reference, or a hash of the entry
+	Date      string
+	Amount    int    // positive for credits
+	Currency  string // ISO 4217 code, if the statement gives one
+	Name      string
+	Reference string
+}
+
+// Column names of bank statements in CSV format, as configured under
+// "bank.csv"; each defaults to some common (German) headers. References
+// spread over several columns are joined.
+type bankCSVConfig struct {
+	Delimiter string
+	Date      []string
+	Amount    []string
+	Name      []string
+	Reference []string
+}
+
+var defaultBankCSVConfig = bankCSVConfig{
+	Date:      []string{"date", "booking date", "buchungstag", "buchungsdatum", "datum"},
+	Amount:    []string{"amount", "betrag", "betrag (eur)", "umsatz"},
+	Name:      []string{"name", "payer", "counterparty", "name zahlungsbeteiligter", "auftraggeber", "beguenstigter/zahlungspflichtiger", "zahlungspflichtiger"},
+	Reference: []string{"reference", "purpose", "remittance information", "verwendungszweck"},
+}
+
+const importedBankEntriesFile = "bank-imported.json"
+
+func newImportCommand(cli *CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "import transactions from elsewhere",
+		Args:  cobra.NoArgs,
+		RunE:  func(cmd *cobra.Command, _ []string) error { return cmd.Usage() },
+	}
+
+	bank := &cobra.Command{
+		Use:   "bank <statement>",
+		Short: "book deposits from a CAMT.053 or CSV bank statement",
+		Long: `book deposits from a CAMT.053 or CSV bank statement
+
+Incoming payments are matched to users by their reference, as shown
+by deposit-qr, e.g. "SL-42 alice"; both the ID and the name have to
+match. Payments that were already booked by a previous import are
+skipped, so statements may overlap, as are payments in a currency
+other than the server's.`,
+		Args: cobra.ExactArgs(1),
+		RunE: cli.wrap(runPreHooks, runImportBank, runPostHooks),
+	}
+
+	bank.Flags().String("format", "", "statement format (camt|csv; default: by file extension)")
+	bank.Flags().Bool("confirm", false, "confirm booking; dry-runs otherwise")
+
+	cmd.AddCommand(bank)
+	return cmd
+}
+
+func runImportBank(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	format, _ := cmd.Flags().GetString("format")
+	if format == "" {
+		format = "csv"
+		if strings.EqualFold(filepath.Ext(args[0]), ".xml") {
+			format = "camt"
+		}
+	}
+
+	bank, err := loadBankConfig(cli)
+	if err != nil {
+		return err
+	}
+
+	buf, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	var entries []bankEntry
+	switch format {
+	case "camt":
+		entries, err = parseCAMT053(buf)
+	case "csv":
+		config := defaultBankCSVConfig
+		err = cli.Viper.UnmarshalKey("bank.csv", &config)
+		if err == nil {
+			entries, err = parseBankCSV(buf, &config)
+		}
+	default:
+		return newError(ErrorUsage, "unknown statement format '%s'", format)
+	}
+	if err != nil {
+		return newError(ErrorValidation, "can't parse %s: %s", args[0], err)
+	}
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return err
+	}
+
+	imported := loadImportedBankEntries()
+
+	type deposit struct {
+		entry bankEntry
+		user  *schema.User
+	}
+	var deposits []deposit
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "DATE\tAMOUNT\tFROM\tREFERENCE\tBOOKING")
+	for _, entry := range entries {
+		if entry.Amount <= 0 {
+			continue
+		}
+
+		amount := formatCurrency(settings, entry.Amount)
+		currency := settings.I18n.Currency.Alpha3
+		foreign := entry.Currency != "" && currency != "" && !strings.EqualFold(entry.Currency, currency)
+		if foreign {
+			amount = fmt.Sprintf("%.2f %s", CurrencyIntToFloat64(entry.Amount), entry.Currency)
+		}
+
+		status := ""
+		var user *schema.User
+		if imported[entry.ID] {
+			status = "already booked"
+		} else if foreign {
+			status = fmt.Sprintf("in %s, not %s", entry.Currency, currency)
+		} else if id, ok := bank.matchReference(entry.Reference); !ok {
+			status = "no user reference"
+		} else if user, _, err = cli.Client.User.Get(id); isNotFound(err) {
+			status = fmt.Sprintf("no user #%d", id)
+		} else if err != nil {
+			return err
+		} else if !bank.namesUser(entry.Reference, user) {
+			status = fmt.Sprintf("name doesn't match user #%d", id)
+			user = nil
+		} else if err = validateDelta(settings, user, entry.Amount); err != nil {
+			status = err.Error()
+		} else {
+			status = fmt.Sprintf("to #%d (%s)", user.ID, user.Name)
+			deposits = append(deposits, deposit{entry, user})
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			entry.Date,
+			amount,
+			entry.Name,
+			truncateRunes(strings.Join(strings.Fields(entry.Reference), " "), 40),
+			status,
+		)
+	}
+	w.Flush()
+
+	if len(deposits) == 0 {
+		fmt.Println("nothing to book")
+		return nil
+	}
+
+	confirmed, _ := cmd.Flags().GetBool("confirm")
+	if !confirmed {
+		return newError(ErrorDryRun, "would book %d deposits", len(deposits))
+	}
+
+	var txs []*schema.Transaction
+	for _, d := range deposits {
+		comment := fmt.Sprintf("bank transfer %s", d.entry.Date)
+		if d.entry.Name != "" {
+			comment += fmt.Sprintf(" from %s", d.entry.Name)
+		}
+
+		tx, err := createDelta(cli, d.user, d.entry.Amount, comment)
+		if err != nil {
+			if len(txs) > 0 {
+				cli.result = txs
+			}
+			return err
+		}
+		txs = append(txs, tx)
+
+		// saved as we go, so a failed import can simply be rerun
+		imported[d.entry.ID] = true
+		saveImportedBankEntries(imported)
+
+		fmt.Printf("booked %s for user #%d (%s) as transaction #%d\n",
+			formatCurrency(settings, tx.Value), tx.Issuer.ID, tx.Issuer.Name, tx.ID)
+	}
+
+	cli.result = txs
+	return nil
+}
+
+func loadImportedBankEntries() map[string]bool {
+
+	imported := map[string]bool{}
+	path, err := statePath(importedBankEntriesFile)
+	if err != nil {
+		return imported
+	}
+
+	if buf, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(buf, &imported)
+	}
+	return imported
+}
+
+func saveImportedBankEntries(imported map[string]bool) {
+
+	path, err := statePath(importedBankEntriesFile)
+	if err != nil {
+		return
+	}
+
+	buf, err := json.Marshal(imported)
+	if err != nil {
+		return
+	}
+
+	// write atomically, lest entries get booked twice
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0600)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: can't save imported entries: %s\n", err)
+	}
+}
+
+// The parts of a CAMT.053 statement needed for deposits. Element names
+// are matched without namespaces, so all versions of the format work.
+type camtDocument struct {
+	Statements []struct {
+		Entries []camtEntry `xml:"Ntry"`
+	} `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type camtEntry struct {
+	Amount    camtAmount `xml:"Amt"`
+	Direction string     `xml:"CdtDbtInd"`
+	Status    struct {
+		Text string `xml:",chardata"`
+		Code string `xml:"Cd"` // since camt.053.001.08
+	} `xml:"Sts"`
+	BookingDate string `xml:"BookgDt>Dt"`
+	BookingTime string `xml:"BookgDt>DtTm"`
+	Reference   string `xml:"AcctSvcrRef"`
+	Details     []struct {
+		Amount     *camtAmount `xml:"AmtDtls>TxAmt>Amt"`
+		EndToEndID string      `xml:"Refs>EndToEndId"`
+		Reference  string      `xml:"Refs>AcctSvcrRef"`
+		Debtor     string      `xml:"RltdPties>Dbtr>Nm"`
+		DebtorPty  string      `xml:"RltdPties>Dbtr>Pty>Nm"` // since camt.053.001.08
+		Remittance []string    `xml:"RmtInf>Ustrd"`
+	} `xml:"NtryDtls>TxDtls"`
+}
+
+func parseCAMT053(buf []byte) ([]bankEntry, error) {
+
+	var doc camtDocument
+	err := xml.Unmarshal(buf, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []bankEntry
+	for _, stmt := range doc.Statements {
+		for _, e := range stmt.Entries {
+
+			status := strings.TrimSpace(e.Status.Text)
+			if e.Status.Code != "" {
+				status = e.Status.Code
+			}
+			if e.Direction != "CRDT" || status != "BOOK" {
+				continue
+			}
+
+			date := e.BookingDate
+			if date == "" && len(e.BookingTime) >= 10 {
+				date = e.BookingTime[:10]
+			}
+
+			// batched entries carry details for each transfer
+			for i, d := range e.Details {
+				amount := d.Amount
+				if amount == nil && len(e.Details) == 1 {
+					amount = &e.Amount
+				}
+				if amount == nil {
+					fmt.Fprintf(os.Stderr, "Warning: skipping transfer %d of the entry booked %s, as it has no amount\n",
+						i+1, date)
+					continue
+				}
+				value, err := parseStatementAmount(amount.Value)
+				if err != nil {
+					return nil, err
+				}
+
+				id := d.Reference
+				if id == "" && e.Reference != "" {
+					id = fmt.Sprintf("%s/%d", e.Reference, i)
+				}
+
+				name := d.Debtor
+				if name == "" {
+					name = d.DebtorPty
+				}
+
+				entry := bankEntry{
+					ID:        id,
+					Date:      date,
+					Amount:    value,
+					Currency:  amount.Currency,
+					Name:      strings.TrimSpace(name),
+					Reference: strings.Join(d.Remittance, " "),
+				}
+				if entry.ID == "" {
+					entry.ID = hashBankEntry(&entry, d.EndToEndID)
+				}
+				entries = append(entries, entry)
+			}
+
+			if len(e.Details) == 0 {
+				value, err := parseStatementAmount(e.Amount.Value)
+				if err != nil {
+					return nil, err
+				}
+				entry := bankEntry{ID: e.Reference, Date: date, Amount: value, Currency: e.Amount.Currency}
+				if entry.ID == "" {
+					entry.ID = hashBankEntry(&entry, "")
+				}
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+func parseBankCSV(buf []byte, config *bankCSVConfig) ([]bankEntry, error) {
+
+	// banks like to prepend a byte order mark
+	buf = bytes.TrimPrefix(buf, []byte("\xef\xbb\xbf"))
+
+	delimiter := config.Delimiter
+	if delimiter == "" {
+		delimiter = sniffDelimiter(buf)
+	}
+
+	r := csv.NewReader(bytes.NewReader(buf))
+	r.Comma = []rune(delimiter)[0]
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	// some banks put account details above the header,
+	// so skip ahead to the first row naming an amount column
+	var header []string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no header with an amount column found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(findColumns(row, config.Amount)) > 0 {
+			header = row
+			break
+		}
+	}
+
+	date := findColumns(header, config.Date)
+	amount := findColumns(header, config.Amount)
+	name := findColumns(header, config.Name)
+	reference := findColumns(header, config.Reference)
+	if len(reference) == 0 {
+		return nil, fmt.Errorf("no reference column found")
+	}
+
+	field := func(row []string, columns []int) string {
+		var parts []string
+		for _, i := range columns {
+			if i < len(row) && strings.TrimSpace(row[i]) != "" {
+				parts = append(parts, strings.TrimSpace(row[i]))
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+
+	var entries []bankEntry
+	seen := map[string]int{}
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// e.g. a trailing summary
+		if amount[0] >= len(row) || strings.TrimSpace(row[amount[0]]) == "" {
+			continue
+		}
+
+		value, err := parseStatementAmount(row[amount[0]])
+		if err != nil {
+			return nil, err
+		}
+
+		entry := bankEntry{
+			Date:      field(row, date),
+			Amount:    value,
+			Name:      field(row, name),
+			Reference: field(row, reference),
+		}
+
+		// tell apart identical transfers on the same day
+		key := hashBankEntry(&entry, "")
+		seen[key]++
+		entry.ID = hashBankEntry(&entry, strconv.Itoa(seen[key]))
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Guesses the delimiter from the first line.
+func sniffDelimiter(buf []byte) string {
+	line := string(buf)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	best, count := ",", 0
+	for _, d := range []string{";", "\t", ","} {
+		if n := strings.Count(line, d); n > count {
+			best, count = d, n
+		}
+	}
+	return best
+}
+
+// Returns the indices of columns with any of the names, ignoring case.
+func findColumns(header []string, names []string) []int {
+	var columns []int
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		for _, name := range names {
+			if column == strings.ToLower(name) ||
+				// e.g. "Verwendungszweck 1", "Verwendungszweck 2"
+				strings.HasPrefix(column, strings.ToLower(name)+" ") {
+				columns = append(columns, i)
+				break
+			}
+		}
+	}
+	return columns
+}
+
+// Parses amounts like "1.234,56", "1,234.56", "1.234", "-5" or "12,50 EUR".
+func parseStatementAmount(s string) (int, error) {
+
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "EUR"))
+	s = strings.NewReplacer(" ", "", "€", "", "'", "").Replace(s)
+
+	comma, dot := strings.LastIndex(s, ","), strings.LastIndex(s, ".")
+	switch {
+	case comma >= 0 && dot >= 0:
+		// whichever separator comes last is the decimal one
+		if comma > dot {
+			s = strings.Replace(strings.Replace(s, ".", "", -1), ",", ".", 1)
+		} else {
+			s = strings.Replace(s, ",", "", -1)
+		}
+
+	case comma >= 0 || dot >= 0:
+		// with only one kind of separator, it groups thousands if it's
+		// repeated or followed by three digits, as in "1.234"; cents
+		// never have more than two
+		sep, i := ",", comma
+		if dot >= 0 {
+			sep, i = ".", dot
+		}
+		if strings.Count(s, sep) > 1 || len(s)-i-1 == 3 {
+			s = strings.Replace(s, sep, "", -1)
+		} else {
+			s = strings.Replace(s, sep, ".", 1)
+		}
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount '%s'", s)
+	}
+	return CurrencyFloat64ToInt(f), nil
+}
+
+// Identifies entries without a reference from the bank, so that
+// importing overlapping statements doesn't book them twice.
+func hashBankEntry(entry *bankEntry, extra string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s\x00%s\x00%s", entry.Date, entry.Amount, entry.Name, entry.Reference, extra)
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
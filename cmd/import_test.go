@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestParseStatementAmount(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   int
+	}{
+		{"12,50", 1250},
+		{"12.50", 1250},
+		{"12,5", 1250},
+		{"-5", -500},
+		{"-30,00", -3000},
+		{"1.234,56", 123456},
+		{"1,234.56", 123456},
+		{"1'234.56", 123456},
+		{"1.234", 123400},
+		{"1,234", 123400},
+		{"1.234.567", 123456700},
+		{"1 234,56", 123456},
+		{"12,50 EUR", 1250},
+		{"12,50€", 1250},
+		{" 7 ", 700},
+	}
+
+	for _, test := range tests {
+		got, err := parseStatementAmount(test.amount)
+		if err != nil || got != test.want {
+			t.Errorf("parseStatementAmount(%q) = %d, %v; want %d", test.amount, got, err, test.want)
+		}
+	}
+
+	for _, amount := range []string{"", "EUR", "abc", "1,2,3.4.5"} {
+		if got, err := parseStatementAmount(amount); err == nil {
+			t.Errorf("parseStatementAmount(%q) = %d, want an error", amount, got)
+		}
+	}
+}
+
+func TestParseBankCSV(t *testing.T) {
+	statement := "\xef\xbb\xbfKontonummer;DE02120300000000202051\n" +
+		"\n" +
+		"Buchungstag;Name Zahlungsbeteiligter;Verwendungszweck 1;Verwendungszweck 2;Betrag (EUR)\n" +
+		"01.10.2026;Alice;SL-42;alice;1.234,50\n" +
+		"02.10.2026;Bob;\"SL-7; bob\";;12,50\n" +
+		"02.10.2026;Bob;\"SL-7; bob\";;12,50\n" +
+		"03.10.2026;Shop;Rechnung;;-30,00\n" +
+		";;;;\n"
+
+	config := defaultBankCSVConfig
+	entries, err := parseBankCSV([]byte(statement), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []bankEntry{
+		{Date: "01.10.2026", Amount: 123450, Name: "Alice", Reference: "SL-42 alice"},
+		{Date: "02.10.2026", Amount: 1250, Name: "Bob", Reference: "SL-7; bob"},
+		{Date: "02.10.2026", Amount: 1250, Name: "Bob", Reference: "SL-7; bob"},
+		{Date: "03.10.2026", Amount: -3000, Name: "Shop", Reference: "Rechnung"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, entry := range entries {
+		id := entry.ID
+		entry.ID = ""
+		if entry != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entry, want[i])
+		}
+		if id == "" {
+			t.Errorf("entry %d has no ID", i)
+		}
+	}
+
+	// identical transfers must not be mistaken for a reimport
+	if entries[1].ID == entries[2].ID {
+		t.Error("identical entries got the same ID")
+	}
+
+	// IDs must be stable across imports
+	again, _ := parseBankCSV([]byte(statement), &config)
+	for i := range entries {
+		if entries[i].ID != again[i].ID {
+			t.Errorf("entry %d got a different ID when parsed again", i)
+		}
+	}
+}
+
+func TestParseBankCSVConfig(t *testing.T) {
+	statement := "Datum,Text,Umsatz\n" +
+		"2026-10-01,SL-42 alice,\"1,234.50\"\n"
+
+	config := defaultBankCSVConfig
+	config.Amount = []string{"Umsatz"}
+	config.Reference = []string{"Text"}
+
+	entries, err := parseBankCSV([]byte(statement), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Amount != 123450 || entries[0].Reference != "SL-42 alice" {
+		t.Errorf("parseBankCSV() = %+v", entries)
+	}
+
+	// without a reference column, nothing can be matched
+	if _, err := parseBankCSV([]byte("Datum;Betrag\n2026-10-01;5,00\n"), &defaultBankCSVConfig); err == nil {
+		t.Error("parseBankCSV() accepted a statement without references")
+	}
+	if _, err := parseBankCSV([]byte("Datum;Text\n2026-10-01;SL-42\n"), &defaultBankCSVConfig); err == nil {
+		t.Error("parseBankCSV() accepted a statement without amounts")
+	}
+}
+
+func TestParseCAMT053(t *testing.T) {
+	statement := `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <Stmt>
+      <Ntry>
+        <Amt Ccy="EUR">12.50</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2026-10-01</Dt></BookgDt>
+        <AcctSvcrRef>REF1</AcctSvcrRef>
+        <NtryDtls><TxDtls>
+          <RltdPties><Dbtr><Nm>Alice</Nm></Dbtr></RltdPties>
+          <RmtInf><Ustrd>SL-42</Ustrd><Ustrd>alice</Ustrd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">30.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2026-10-01</Dt></BookgDt>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">5.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts>PDNG</Sts>
+        <BookgDt><Dt>2026-10-02</Dt></BookgDt>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">15.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts><Cd>BOOK</Cd></Sts>
+        <BookgDt><DtTm>2026-10-03T09:00:00</DtTm></BookgDt>
+        <AcctSvcrRef>BATCH</AcctSvcrRef>
+        <NtryDtls>
+          <TxDtls>
+            <AmtDtls><TxAmt><Amt Ccy="EUR">10.00</Amt></TxAmt></AmtDtls>
+            <RltdPties><Dbtr><Pty><Nm>Bob</Nm></Pty></Dbtr></RltdPties>
+            <RmtInf><Ustrd>SL-7 bob</Ustrd></RmtInf>
+          </TxDtls>
+          <TxDtls>
+            <RltdPties><Dbtr><Nm>Dave</Nm></Dbtr></RltdPties>
+            <RmtInf><Ustrd>SL-11 dave</Ustrd></RmtInf>
+          </TxDtls>
+          <TxDtls>
+            <AmtDtls><TxAmt><Amt Ccy="EUR">5.00</Amt></TxAmt></AmtDtls>
+            <Refs><AcctSvcrRef>REF3</AcctSvcrRef></Refs>
+            <RltdPties><Dbtr><Nm>Carol</Nm></Dbtr></RltdPties>
+            <RmtInf><Ustrd>SL-9 carol</Ustrd></RmtInf>
+          </TxDtls>
+        </NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="CHF">20.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <BookgDt><Dt>2026-10-04</Dt></BookgDt>
+        <AcctSvcrRef>REF4</AcctSvcrRef>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+
+	entries, err := parseCAMT053([]byte(statement))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []bankEntry{
+		{ID: "REF1/0", Date: "2026-10-01", Amount: 1250, Currency: "EUR", Name: "Alice", Reference: "SL-42 alice"},
+		{ID: "BATCH/0", Date: "2026-10-03", Amount: 1000, Currency: "EUR", Name: "Bob", Reference: "SL-7 bob"},
+		// Dave's transfer has no amount, so it's skipped
+		{ID: "REF3", Date: "2026-10-03", Amount: 500, Currency: "EUR", Name: "Carol", Reference: "SL-9 carol"},
+		{ID: "REF4", Date: "2026-10-04", Amount: 2000, Currency: "CHF"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i := range entries {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	if _, err := parseCAMT053([]byte("<Document>")); err == nil {
+		t.Error("parseCAMT053() accepted malformed XML")
+	}
+}
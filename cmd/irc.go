@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal IRC client for the bot; see RFC 2812. Anyone can take a
+// nick, so senders are identified by the account they're logged in to,
+// as the server tells us with the IRCv3 account-tag capability.
+type ircTransport struct {
+	conn net.Conn
+	r    *bufio.Reader
+	nick string
+
+	mu     sync.Mutex // guards writes
+	closed bool
+}
+
+type ircOptions struct {
+	Server   string // irc://host:port, or ircs:// for TLS
+	Nick     string
+	Password string
+	Channels []string
+}
+
+func dialIRC(opts *ircOptions) (*ircTransport, error) {
+
+	if opts.Server == "" || opts.Nick == "" {
+		return nil, newError(ErrorUsage, "bot.irc.server and bot.irc.nick must be configured")
+	}
+
+	u, err := url.Parse(opts.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	switch u.Scheme {
+	case "irc":
+		conn, err = dialer.Dial("tcp", withDefaultPort(u.Host, "6667"))
+	case "ircs":
+		conn, err = tls.DialWithDialer(dialer, "tcp", withDefaultPort(u.Host, "6697"), nil)
+	default:
+		return nil, fmt.Errorf("unsupported IRC server scheme '%s'", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t := &ircTransport{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		nick: opts.Nick,
+	}
+
+	// registration waits for CAP END
+	t.send("CAP REQ :account-tag")
+	if opts.Password != "" {
+		t.send("PASS %s", opts.Password)
+	}
+	t.send("NICK %s", opts.Nick)
+	t.send("USER %s 0 * :strichliste-cli", opts.Nick)
+
+	// wait for the welcome before joining
+	tagged := false
+	for {
+		msg, err := t.read()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		switch msg.Command {
+		case "PING":
+			// some servers want a PONG before they welcome us
+			t.send("PONG :%s", strings.Join(msg.Params, " "))
+		case "CAP":
+			if len(msg.Params) < 3 {
+				continue
+			}
+			switch msg.Params[1] {
+			case "ACK":
+				tagged = containsString(strings.Fields(msg.Params[2]), "account-tag")
+				t.send("CAP END")
+			case "NAK":
+				t.send("CAP END")
+			}
+		case "001":
+			if !tagged {
+				conn.Close()
+				return nil, fmt.Errorf("server doesn't support account-tag, so senders can't be verified")
+			}
+			for _, channel := range opts.Channels {
+				t.send("JOIN %s", channel)
+			}
+			return t, nil
+		case "433":
+			conn.Close()
+			return nil, fmt.Errorf("nick '%s' is already in use", opts.Nick)
+		case "ERROR":
+			conn.Close()
+			return nil, fmt.Errorf("server closed connection: %s", strings.Join(msg.Params, " "))
+		}
+	}
+}
+
+func (t *ircTransport) Receive() (*botMessage, error) {
+	for {
+		line, err := t.read()
+		if err != nil {
+			t.mu.Lock()
+			closed := t.closed
+			t.mu.Unlock()
+			if closed {
+				return nil, io.EOF
+			}
+			if err == io.EOF {
+				return nil, newError(ErrorNetwork, "connection to IRC server lost")
+			}
+			return nil, err
+		}
+
+		switch line.Command {
+		case "PING":
+			t.send("PONG :%s", strings.Join(line.Params, " "))
+
+		case "PRIVMSG":
+			if len(line.Params) != 2 {
+				continue
+			}
+
+			msg := &botMessage{
+				Nick:    strings.SplitN(line.Prefix, "!", 2)[0],
+				Account: line.Tags["account"],
+				Text:    line.Params[1],
+			}
+			if target := line.Params[0]; !strings.EqualFold(target, t.nick) {
+				msg.Channel = target
+			}
+			return msg, nil
+		}
+	}
+}
+
+func (t *ircTransport) Reply(msg *botMessage, text string) error {
+	target := msg.Channel
+	if target == "" {
+		target = msg.Nick
+	}
+
+	// no newlines, lest they smuggle in commands
+	text = strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+	return t.send("PRIVMSG %s :%s", target, text)
+}
+
+func (t *ircTransport) Close() error {
+	t.send("QUIT :bye")
+
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	return t.conn.Close()
+}
+
+func (t *ircTransport) send(format string, args ...interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := fmt.Fprintf(t.conn, format+"\r\n", args...)
+	return err
+}
+
+// A line received from the server.
+type ircMessage struct {
+	Tags    map[string]string // IRCv3 message tags
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// Reads a line, split into tags, prefix, command and parameters;
+// the trailing parameter (after " :") may contain spaces.
+func (t *ircTransport) read() (*ircMessage, error) {
+
+	line, err := t.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	return parseIRCMessage(strings.TrimRight(line, "\r\n")), nil
+}
+
+func parseIRCMessage(line string) *ircMessage {
+
+	msg := &ircMessage{Tags: map[string]string{}}
+
+	if strings.HasPrefix(line, "@") {
+		parts := strings.SplitN(line[1:], " ", 2)
+		for _, tag := range strings.Split(parts[0], ";") {
+			kv := strings.SplitN(tag, "=", 2)
+			if len(kv) == 2 {
+				msg.Tags[kv[0]] = ircTagValue.Replace(kv[1])
+			} else {
+				msg.Tags[kv[0]] = ""
+			}
+		}
+		line = ""
+		if len(parts) == 2 {
+			line = strings.TrimLeft(parts[1], " ")
+		}
+	}
+
+	if strings.HasPrefix(line, ":") {
+		parts := strings.SplitN(line[1:], " ", 2)
+		msg.Prefix = parts[0]
+		line = ""
+		if len(parts) == 2 {
+			line = parts[1]
+		}
+	}
+
+	trailing, hasTrailing := "", false
+	if i := strings.Index(line, " :"); i >= 0 {
+		trailing, hasTrailing = line[i+2:], true
+		line = line[:i]
+	} else if strings.HasPrefix(line, ":") {
+		trailing, hasTrailing = line[1:], true
+		line = ""
+	}
+
+	msg.Params = strings.Fields(line)
+	if len(msg.Params) > 0 {
+		msg.Command, msg.Params = strings.ToUpper(msg.Params[0]), msg.Params[1:]
+	}
+	if hasTrailing {
+		msg.Params = append(msg.Params, trailing)
+	}
+	return msg
+}
+
+// Unescapes tag values, as per the IRCv3 message-tags spec.
+var ircTagValue = strings.NewReplacer(`\:`, ";", `\s`, " ", `\\`, `\`, `\r`, "\r", `\n`, "\n")
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIRCMessage(t *testing.T) {
+	tests := []struct {
+		line string
+		want ircMessage
+	}{
+		{
+			"PING :irc.example.org",
+			ircMessage{Tags: map[string]string{}, Command: "PING", Params: []string{"irc.example.org"}},
+		},
+		{
+			":al!a@host PRIVMSG #hs :!buy club mate 2",
+			ircMessage{
+				Tags:    map[string]string{},
+				Prefix:  "al!a@host",
+				Command: "PRIVMSG",
+				Params:  []string{"#hs", "!buy club mate 2"},
+			},
+		},
+		{
+			"@account=alice;time=2019-05-04T20:15:02.000Z :al!a@host PRIVMSG sl :!balance",
+			ircMessage{
+				Tags:    map[string]string{"account": "alice", "time": "2019-05-04T20:15:02.000Z"},
+				Prefix:  "al!a@host",
+				Command: "PRIVMSG",
+				Params:  []string{"sl", "!balance"},
+			},
+		},
+		{
+			`@account=a\sb\:c;draft/flag :srv cap * ACK :account-tag`,
+			ircMessage{
+				Tags:    map[string]string{"account": "a b;c", "draft/flag": ""},
+				Prefix:  "srv",
+				Command: "CAP",
+				Params:  []string{"*", "ACK", "account-tag"},
+			},
+		},
+		{
+			":srv 001 sl :Welcome to IRC",
+			ircMessage{Tags: map[string]string{}, Prefix: "srv", Command: "001", Params: []string{"sl", "Welcome to IRC"}},
+		},
+	}
+
+	for _, test := range tests {
+		got := parseIRCMessage(test.line)
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("parseIRCMessage(%q) = %+v, want %+v", test.line, *got, test.want)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/qr"
+	"github.com/spf13/cobra"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A label or card to print: a barcode with a title and some
+// smaller text, e.g. an article's price.
+type label struct {
+	File     string // base name, for formats with one file per label
+	Title    string
+	Subtitle string
+	Code     barcode.Barcode
+}
+
+// Size of a label and how many fit on an A4 sheet, in points.
+type labelLayout struct {
+	Width, Height float64
+	Columns, Rows int
+}
+
+const mm = 72 / 25.4
+
+var (
+	// common 3x8 sticker sheets
+	articleLabelLayout = labelLayout{70 * mm, 37 * mm, 3, 8}
+
+	// ID-1, i.e. credit card size
+	userCardLayout = labelLayout{85.6 * mm, 54 * mm, 2, 5}
+
+	a4Width, a4Height = 210 * mm, 297 * mm
+)
+
+// Registers the flags shared by article label and user card.
+func addLabelFlags(cmd *cobra.Command, symbologies string) {
+	cmd.Flags().Bool("all", false, "include all active ones")
+	cmd.Flags().String("format", "pdf", "output format (pdf|svg|png)")
+	cmd.Flags().String("dest", "", "file for pdf, directory for svg and png (default: current directory)")
+	cmd.Flags().String("type", "", "kind of barcode ("+symbologies+")")
+}
+
+// Encodes content as a barcode of the given kind:
+// ean13 (or ean8, by length), code128 or qr.
+func encodeBarcode(kind, content string) (barcode.Barcode, error) {
+
+	var code barcode.Barcode
+	var err error
+	switch kind {
+	case "ean13":
+		code, err = ean.Encode(content)
+	case "code128":
+		code, err = code128.Encode(content)
+	case "qr":
+		code, err = qr.Encode(content, qr.M, qr.Auto)
+	default:
+		return nil, newError(ErrorUsage, "unknown barcode type '%s'", kind)
+	}
+
+	if err != nil {
+		return nil, newError(ErrorValidation, "can't encode '%s' as %s: %s", content, kind, err)
+	}
+	return code, nil
+}
+
+// Whether a code is a valid EAN-8 or EAN-13, check digit included.
+func isEAN(code string) bool {
+	if len(code) != 8 && len(code) != 13 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return code[len(code)-1] == eanCheckDigit(code[:len(code)-1])
+}
+
+func eanCheckDigit(code string) byte {
+	sum := 0
+	for i := range code {
+		// weights alternate 3, 1, ... from the right
+		digit := int(code[len(code)-1-i] - '0')
+		if i%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// Writes labels in the given format; see addLabelFlags.
+func writeLabels(labels []label, layout labelLayout, format, dest, defaultFile string) error {
+
+	if format == "pdf" {
+		if dest == "" {
+			dest = defaultFile
+		}
+		err := ioutil.WriteFile(dest, renderLabelsPDF(labels, layout), 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote %d labels to %s\n", len(labels), dest)
+		return nil
+	}
+
+	if format != "svg" && format != "png" {
+		return newError(ErrorUsage, "unknown format '%s'", format)
+	}
+
+	if dest == "" {
+		dest = "."
+	}
+	err := os.MkdirAll(dest, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range labels {
+		var buf []byte
+		if format == "svg" {
+			buf = renderLabelSVG(&l, layout)
+		} else {
+			buf, err = renderBarcodePNG(l.Code)
+			if err != nil {
+				return err
+			}
+		}
+
+		path := filepath.Join(dest, l.File+"."+format)
+		err := ioutil.WriteFile(path, buf, 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", path)
+	}
+	return nil
+}
+
+// Returns the dark modules of a barcode as runs per row, so that
+// each run can be drawn as one rectangle.
+func barcodeRuns(code barcode.Barcode, f func(x, y, n int)) {
+	bounds := code.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		start := -1
+		for x := bounds.Min.X; x <= bounds.Max.X; x++ {
+			dark := x < bounds.Max.X && isDark(code.At(x, y))
+			if dark && start < 0 {
+				start = x
+			} else if !dark && start >= 0 {
+				f(start-bounds.Min.X, y-bounds.Min.Y, x-start)
+				start = -1
+			}
+		}
+	}
+}
+
+func isDark(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r+g+b < 3*0x8000
+}
+
+// Something labels can be drawn on, with the origin at the top left.
+type canvas interface {
+	rect(x, y, w, h float64)
+	text(x, y, size float64, bold bool, s string)
+}
+
+// Draws a label into the given box: 2D codes go on the left with the
+// text beside them, 1D codes below the text with their digits beneath.
+func drawLabel(c canvas, l *label, x, y, w, h float64) {
+
+	margin := 3 * mm
+	x, y, w, h = x+margin, y+margin, w-2*margin, h-2*margin
+
+	bounds := l.Code.Bounds()
+	if l.Code.Metadata().Dimensions == 2 {
+		// with the quiet zone of 4 modules
+		module := h / float64(bounds.Dx()+8)
+		barcodeRuns(l.Code, func(mx, my, n int) {
+			c.rect(x+float64(mx+4)*module, y+float64(my+4)*module, float64(n)*module, module)
+		})
+
+		tx := x + h + margin
+		c.text(tx, y+14, 12, true, fitText(l.Title, w-h-margin, 12))
+		c.text(tx, y+28, 9, false, fitText(l.Subtitle, w-h-margin, 9))
+		return
+	}
+
+	c.text(x, y+12, 11, true, fitText(l.Title, w, 11))
+	c.text(x, y+24, 9, false, fitText(l.Subtitle, w, 9))
+
+	// with the quiet zone of 10 modules on either side
+	top, bottom := y+30, y+h-10
+	module := w / float64(bounds.Dx()+20)
+	barcodeRuns(l.Code, func(mx, _, n int) {
+		c.rect(x+float64(mx+10)*module, top, float64(n)*module, bottom-top)
+	})
+	c.text(x+10*module, y+h-1, 8, false, l.Code.Content())
+}
+
+// Shortens text to roughly fit the width, assuming Helvetica's
+// average character width of about half the font size.
+func fitText(s string, width, size float64) string {
+	n := int(width / (size * 0.5))
+	if len([]rune(s)) <= n || n < 3 {
+		return s
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
+
+type svgCanvas struct {
+	buf bytes.Buffer
+}
+
+func (c *svgCanvas) rect(x, y, w, h float64) {
+	fmt.Fprintf(&c.buf, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f"/>`+"\n", x, y, w, h)
+}
+
+func (c *svgCanvas) text(x, y, size float64, bold bool, s string) {
+	weight := "normal"
+	if bold {
+		weight = "bold"
+	}
+	fmt.Fprintf(&c.buf, `<text x="%.2f" y="%.2f" font-size="%.1f" font-weight="%s">%s</text>`+"\n",
+		x, y, size, weight, svgEscaper.Replace(s))
+}
+
+var svgEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func renderLabelSVG(l *label, layout labelLayout) []byte {
+
+	c := &svgCanvas{}
+	fmt.Fprintf(&c.buf, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="%.1fmm" height="%.1fmm" viewBox="0 0 %.2f %.2f" font-family="Helvetica, Arial, sans-serif">
+<rect width="100%%" height="100%%" fill="white"/>
+`, layout.Width/mm, layout.Height/mm, layout.Width, layout.Height)
+
+	drawLabel(c, l, 0, 0, layout.Width, layout.Height)
+	c.buf.WriteString("</svg>\n")
+	return c.buf.Bytes()
+}
+
+// Renders just the barcode, e.g. for use in other label software.
+func renderBarcodePNG(code barcode.Barcode) ([]byte, error) {
+
+	// module size and quiet zone in pixels
+	scale, quiet, height := 3, 30, 120
+	if code.Metadata().Dimensions == 2 {
+		scale, quiet = 8, 32
+		height = code.Bounds().Dy() * scale
+	}
+
+	bounds := code.Bounds()
+	width := bounds.Dx()*scale + 2*quiet
+	img := image.NewGray(image.Rect(0, 0, width, height+2*quiet))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	barcodeRuns(code, func(mx, my, n int) {
+		rows := scale
+		if code.Metadata().Dimensions == 1 {
+			rows = height
+		}
+		for y := 0; y < rows; y++ {
+			for x := 0; x < n*scale; x++ {
+				img.SetGray(quiet+mx*scale+x, quiet+my*scale+y, color.Gray{})
+			}
+		}
+	})
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
+
+// Draws into a PDF content stream; PDF's origin is at the bottom left.
+type pdfCanvas struct {
+	buf    bytes.Buffer
+	height float64
+}
+
+func (c *pdfCanvas) rect(x, y, w, h float64) {
+	fmt.Fprintf(&c.buf, "%.2f %.2f %.2f %.2f re f\n", x, c.height-y-h, w, h)
+}
+
+func (c *pdfCanvas) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&c.buf, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n",
+		font, size, x, c.height-y, pdfString(s))
+}
+
+// Encodes text for the standard fonts' WinAnsiEncoding,
+// i.e. roughly Latin-1, and escapes it for a PDF string.
+func pdfString(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '€':
+			buf.WriteString(`\200`)
+		case r >= 0x20 && r < 0x7f:
+			buf.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&buf, `\%03o`, r)
+		default:
+			buf.WriteByte('?')
+		}
+	}
+	return buf.String()
+}
+
+// Renders labels onto as many A4 sheets as needed, centered.
+func renderLabelsPDF(labels []label, layout labelLayout) []byte {
+
+	perPage := layout.Columns * layout.Rows
+	left := (a4Width - float64(layout.Columns)*layout.Width) / 2
+	top := (a4Height - float64(layout.Rows)*layout.Height) / 2
+
+	var pages [][]byte
+	for start := 0; start < len(labels); start += perPage {
+		c := &pdfCanvas{height: a4Height}
+		for i := start; i < len(labels) && i < start+perPage; i++ {
+			col, row := (i-start)%layout.Columns, (i-start)/layout.Columns
+			drawLabel(c, &labels[i],
+				left+float64(col)*layout.Width, top+float64(row)*layout.Height,
+				layout.Width, layout.Height)
+		}
+		pages = append(pages, c.buf.Bytes())
+	}
+
+	// objects: 1 catalog, 2 page tree, 3-4 fonts, then a page
+	// and its content stream for each page
+	var objects []string
+	var kids []string
+	for i, content := range pages {
+		page := 5 + 2*i
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+				"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				a4Width, a4Height, page+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+		)
+	}
+	objects = append([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}, objects...)
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(objects)+1, xref)
+	return buf.Bytes()
+}
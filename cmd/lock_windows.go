@@ -0,0 +1,10 @@
+package cmd
+
+import (
+	"os"
+)
+
+// Windows lacks flock, so concurrent updates aren't guarded there.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -33,12 +33,12 @@ func runMetrics(cli *CLI, cmd *cobra.Command, args []string) error {
 
 func userMetrics(cli *CLI, username string) error {
 
-	settings, _, err := cli.Client.Settings.Get()
+	settings, err := cli.Settings()
 	if err != nil {
 		return err
 	}
 
-	user, _, err := cli.Client.User.GetByName(username)
+	user, err := resolveUser(cli, username)
 	if err != nil {
 		return err
 	}
@@ -87,7 +87,7 @@ func userMetrics(cli *CLI, username string) error {
 
 func systemMetrics(cli *CLI) error {
 
-	settings, _, err := cli.Client.Settings.Get()
+	settings, err := cli.Settings()
 	if err != nil {
 		return err
 	}
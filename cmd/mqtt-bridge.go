@@ -0,0 +1,325 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	s "github.com/jktr/go-strichliste"
+	"github.com/jktr/go-strichliste/schema"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Placeholders in the configured topics.
+const (
+	topicUser    = "{user}"
+	topicArticle = "{article}"
+)
+
+type mqttBridge struct {
+	cli  *CLI
+	conn *mqttConn
+	log  *log.Logger
+
+	buyTopic         []string // split at "/"
+	balanceTopic     string
+	transactionTopic string
+	errorTopic       string
+
+	// balances as last published, by user ID
+	published map[int]int
+}
+
+func newMQTTBridgeCommand(cli *CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mqtt-bridge",
+		Short: "trigger purchases from MQTT and publish balances",
+		Long: `trigger purchases from MQTT and publish balances
+
+Messages on the buy topic purchase an article for a user; the payload
+is the number of articles to buy, or empty to buy one. Retained ones
+are ignored, as the broker replays them on every reconnect. Users and
+articles in topics must be given by ID, barcode or exact name. Balances
+and the last transaction of each user are published as retained
+messages, and refreshed periodically to pick up changes made elsewhere.
+
+Topics are configured in the config file; these are the defaults:
+
+  "mqtt": {
+    "broker": "tcp://localhost:1883",
+    "client-id": "strichliste-cli",
+    "username": "",
+    "password": "",
+    "buy-topic": "strichliste/{user}/buy/{article}",
+    "balance-topic": "strichliste/{user}/balance",
+    "transaction-topic": "strichliste/{user}/transaction",
+    "error-topic": "strichliste/{user}/error"
+  }`,
+		Args: cobra.NoArgs,
+		RunE: cli.wrap(runMQTTBridge),
+	}
+
+	cmd.Flags().String("broker", "tcp://localhost:1883", "MQTT broker to connect to (tcp:// or ssl://)")
+	cli.Viper.BindPFlag("mqtt.broker", cmd.Flags().Lookup("broker"))
+
+	cmd.Flags().Duration("refresh", time.Minute, "how often to republish changed balances")
+	cli.Viper.BindPFlag("mqtt.refresh", cmd.Flags().Lookup("refresh"))
+
+	cli.Viper.SetDefault("mqtt.client-id", "strichliste-cli")
+	cli.Viper.SetDefault("mqtt.buy-topic", "strichliste/{user}/buy/{article}")
+	cli.Viper.SetDefault("mqtt.balance-topic", "strichliste/{user}/balance")
+	cli.Viper.SetDefault("mqtt.transaction-topic", "strichliste/{user}/transaction")
+	cli.Viper.SetDefault("mqtt.error-topic", "strichliste/{user}/error")
+
+	return cmd
+}
+
+func runMQTTBridge(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	v := cli.Viper
+
+	b := &mqttBridge{
+		cli:              cli,
+		log:              log.New(os.Stderr, "[mqtt] ", log.LstdFlags),
+		buyTopic:         strings.Split(v.GetString("mqtt.buy-topic"), "/"),
+		balanceTopic:     v.GetString("mqtt.balance-topic"),
+		transactionTopic: v.GetString("mqtt.transaction-topic"),
+		errorTopic:       v.GetString("mqtt.error-topic"),
+		published:        map[int]int{},
+	}
+
+	if !containsString(b.buyTopic, topicUser) || !containsString(b.buyTopic, topicArticle) {
+		return newError(ErrorUsage, "buy topic must contain both %s and %s", topicUser, topicArticle)
+	}
+
+	interval := v.GetDuration("mqtt.refresh")
+	if interval <= 0 {
+		return newError(ErrorValidation, "refresh must be positive")
+	}
+
+	opts := &mqttOptions{
+		Broker:    v.GetString("mqtt.broker"),
+		ClientID:  v.GetString("mqtt.client-id"),
+		Username:  v.GetString("mqtt.username"),
+		Password:  v.GetString("mqtt.password"),
+		KeepAlive: 30 * time.Second,
+	}
+
+	// there's nobody to ask when things are ambiguous,
+	// nor to notice when a close match was the wrong one
+	cli.noPrompt = true
+	cli.exactMatch = true
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	refresh := time.NewTicker(interval)
+	defer refresh.Stop()
+
+	// reconnect with backoff until stopped
+	delay := time.Second
+	for {
+		err := b.connect(opts)
+		if err == nil {
+			delay = time.Second
+			if b.run(stop, refresh.C) {
+				return b.conn.Close()
+			}
+			b.log.Printf("connection to %s lost: %s", opts.Broker, b.conn.Err())
+		} else {
+			b.log.Printf("connecting to %s failed: %s", opts.Broker, err)
+		}
+
+		b.log.Printf("reconnecting in %s", delay)
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(delay):
+		}
+		if delay < time.Minute {
+			delay *= 2
+		}
+	}
+}
+
+func (b *mqttBridge) connect(opts *mqttOptions) error {
+
+	conn, err := dialMQTT(opts)
+	if err != nil {
+		return err
+	}
+	b.conn = conn
+
+	filter := make([]string, len(b.buyTopic))
+	for i, segment := range b.buyTopic {
+		if segment == topicUser || segment == topicArticle {
+			segment = "+"
+		}
+		filter[i] = segment
+	}
+
+	err = conn.Subscribe(strings.Join(filter, "/"))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	b.log.Printf("connected to %s", opts.Broker)
+
+	// retained messages may have been lost along with the broker
+	b.published = map[int]int{}
+	b.refresh()
+	return nil
+}
+
+// Handles messages until stopped (returns true) or disconnected.
+func (b *mqttBridge) run(stop <-chan os.Signal, refresh <-chan time.Time) bool {
+	for {
+		select {
+		case <-stop:
+			return true
+		case <-refresh:
+			b.refresh()
+		case msg, ok := <-b.conn.Messages:
+			if !ok {
+				return false
+			}
+			b.handle(msg)
+		}
+	}
+}
+
+// Matches a topic against the buy topic, returning the user and article.
+func (b *mqttBridge) matchBuyTopic(topic string) (string, string, bool) {
+
+	segments := strings.Split(topic, "/")
+	if len(segments) != len(b.buyTopic) {
+		return "", "", false
+	}
+
+	var user, article string
+	for i, segment := range segments {
+		switch b.buyTopic[i] {
+		case topicUser:
+			user = segment
+		case topicArticle:
+			article = segment
+		default:
+			if segment != b.buyTopic[i] {
+				return "", "", false
+			}
+		}
+	}
+	return user, article, true
+}
+
+func (b *mqttBridge) handle(msg mqttMessage) {
+
+	username, query, ok := b.matchBuyTopic(msg.Topic)
+	if !ok {
+		return
+	}
+
+	// the broker replays retained messages whenever we resubscribe,
+	// so acting on them would buy the same thing over and over
+	if msg.Retain {
+		b.log.Printf("ignoring retained message on %s", msg.Topic)
+		return
+	}
+
+	count := 1
+	if payload := strings.TrimSpace(string(msg.Payload)); payload != "" {
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			b.fail(username, newError(ErrorValidation, "invalid count '%s'", payload))
+			return
+		}
+		count = n
+	}
+
+	user, err := resolveUser(b.cli, username)
+	if err != nil {
+		b.fail(username, err)
+		return
+	}
+
+	article, err := resolveArticle(b.cli, query)
+	if err != nil {
+		b.fail(user.Name, err)
+		return
+	}
+
+	tx, err := purchase(b.cli, user, article, count, "")
+	if err != nil {
+		b.fail(user.Name, err)
+		return
+	}
+
+	b.log.Printf("user #%d (%s) bought %s (tx #%d)", user.ID, user.Name, describeTransaction(tx), tx.ID)
+	b.publish(&tx.Issuer, tx)
+}
+
+func (b *mqttBridge) fail(username string, err error) {
+	b.log.Printf("purchase for '%s' failed: %s", username, err)
+	b.conn.Publish(userTopic(b.errorTopic, username), []byte(err.Error()), false)
+}
+
+// Publishes the balances and last transactions of all users
+// whose balance changed since they were last published.
+func (b *mqttBridge) refresh() {
+
+	users, err := listUsers(b.cli)
+	if err != nil {
+		b.log.Printf("listing users failed: %s", err)
+		return
+	}
+
+	for _, user := range activeUsers(users) {
+		if balance, ok := b.published[user.ID]; ok && balance == user.Balance {
+			continue
+		}
+
+		// transactions are listed newest first
+		var last *schema.Transaction
+		txs, _, err := b.cli.Client.Transaction.Context(user.ID).List(&s.ListOpts{PerPage: 1})
+		if err != nil {
+			b.log.Printf("listing transactions of user #%d (%s) failed: %s", user.ID, user.Name, err)
+			continue
+		}
+		if len(txs) > 0 {
+			last = &txs[0]
+		}
+
+		user := user
+		b.publish(&user, last)
+	}
+}
+
+func (b *mqttBridge) publish(user *schema.User, tx *schema.Transaction) {
+
+	// wildcards and separators aren't allowed in topic names
+	if strings.ContainsAny(user.Name, "/+#") {
+		return
+	}
+
+	balance := fmt.Sprintf("%.2f", CurrencyIntToFloat64(user.Balance))
+	err := b.conn.Publish(userTopic(b.balanceTopic, user.Name), []byte(balance), true)
+	if err != nil {
+		return // reconnecting will publish it again
+	}
+	b.published[user.ID] = user.Balance
+
+	if tx != nil {
+		if buf, err := json.Marshal(tx); err == nil {
+			b.conn.Publish(userTopic(b.transactionTopic, user.Name), buf, true)
+		}
+	}
+}
+
+func userTopic(pattern, username string) string {
+	return strings.Replace(pattern, topicUser, username, -1)
+}
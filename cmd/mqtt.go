@@ -0,0 +1,342 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// A minimal MQTT 3.1.1 client; just enough to subscribe at QoS 0 and
+// to publish retained messages. See the OASIS spec for packet layouts.
+
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttSubscribe  = 0x82 // includes the reserved flags
+	mqttSuback     = 0x90
+	mqttPingreq    = 0xc0
+	mqttPingresp   = 0xd0
+	mqttDisconnect = 0xe0
+)
+
+type mqttMessage struct {
+	Topic   string
+	Payload []byte
+	Retain  bool // sent by the broker on subscribing, not by a client just now
+}
+
+type mqttConn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	mu       sync.Mutex // guards writes
+	packetID uint16
+
+	Messages chan mqttMessage // closed when the connection is lost
+	err      error            // why it was lost
+	done     chan struct{}
+}
+
+type mqttOptions struct {
+	Broker    string // tcp://host:port, or ssl:// for TLS
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+}
+
+func dialMQTT(opts *mqttOptions) (*mqttConn, error) {
+
+	u, err := url.Parse(opts.Broker)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.Dial("tcp", withDefaultPort(u.Host, "1883"))
+	case "ssl", "tls", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", withDefaultPort(u.Host, "8883"), nil)
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme '%s'", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &mqttConn{
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		Messages: make(chan mqttMessage, 16),
+		done:     make(chan struct{}),
+	}
+
+	err = c.connect(opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// pings are answered, so the broker can't stay quiet for much
+	// longer than the keep alive without the connection being dead
+	go c.readLoop(opts.KeepAlive * 3 / 2)
+	if opts.KeepAlive > 0 {
+		go c.pingLoop(opts.KeepAlive / 2)
+	}
+	return c, nil
+}
+
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func (c *mqttConn) connect(opts *mqttOptions) error {
+
+	flags := byte(0x02) // clean session
+	var payload []byte
+	payload = appendString(payload, opts.ClientID)
+	if opts.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, opts.Username)
+	}
+	if opts.Password != "" {
+		flags |= 0x40
+		payload = appendString(payload, opts.Password)
+	}
+
+	var body []byte
+	body = appendString(body, "MQTT")
+	body = append(body, 4, flags) // protocol level 3.1.1
+	body = appendUint16(body, uint16(opts.KeepAlive/time.Second))
+	body = append(body, payload...)
+
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+
+	err := c.write(mqttConnect, body)
+	if err != nil {
+		return err
+	}
+
+	kind, ack, err := c.read()
+	if err != nil {
+		return err
+	}
+	if kind != mqttConnack || len(ack) != 2 {
+		return errors.New("broker sent no CONNACK")
+	}
+	if ack[1] != 0 {
+		return fmt.Errorf("broker refused connection (code %d)", ack[1])
+	}
+	return nil
+}
+
+// Subscribes to a topic filter at QoS 0; messages arrive on c.Messages.
+func (c *mqttConn) Subscribe(filter string) error {
+
+	c.mu.Lock()
+	c.packetID++
+	id := c.packetID
+	c.mu.Unlock()
+
+	var body []byte
+	body = appendUint16(body, id)
+	body = appendString(body, filter)
+	body = append(body, 0) // QoS 0
+
+	// the SUBACK is consumed by the read loop
+	return c.write(mqttSubscribe, body)
+}
+
+// Publishes a message at QoS 0.
+func (c *mqttConn) Publish(topic string, payload []byte, retain bool) error {
+
+	kind := byte(mqttPublish)
+	if retain {
+		kind |= 0x01
+	}
+
+	var body []byte
+	body = appendString(body, topic)
+	body = append(body, payload...)
+	return c.write(kind, body)
+}
+
+func (c *mqttConn) Close() error {
+	c.write(mqttDisconnect, nil)
+	return c.conn.Close()
+}
+
+// Why the connection was lost; only valid once c.Messages is closed.
+func (c *mqttConn) Err() error {
+	return c.err
+}
+
+// Reads packets until the connection is lost, or nothing, not even a
+// PINGRESP, arrives within the timeout (0 for none).
+func (c *mqttConn) readLoop(timeout time.Duration) {
+
+	defer close(c.Messages)
+	defer close(c.done)
+
+	for {
+		if timeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+
+		kind, body, err := c.read()
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			c.err = fmt.Errorf("broker sent nothing for %s", timeout)
+			c.conn.Close()
+			return
+		}
+		if err != nil {
+			c.err = err
+			c.conn.Close()
+			return
+		}
+
+		switch kind & 0xf0 {
+		case mqttPublish:
+			msg, err := parsePublish(kind, body)
+			if err != nil {
+				c.err = err
+				c.conn.Close()
+				return
+			}
+			c.Messages <- msg
+
+		case mqttPingresp:
+			// only proves the connection is alive
+
+		case mqttSuback:
+			if len(body) == 3 && body[2] == 0x80 {
+				c.err = errors.New("broker rejected subscription")
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *mqttConn) pingLoop(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if c.write(mqttPingreq, nil) != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func parsePublish(kind byte, body []byte) (mqttMessage, error) {
+
+	topic, rest, err := readString(body)
+	if err != nil {
+		return mqttMessage{}, err
+	}
+
+	// we only subscribe at QoS 0, but skip the ID just in case
+	if qos := (kind >> 1) & 0x03; qos > 0 {
+		if len(rest) < 2 {
+			return mqttMessage{}, errors.New("malformed PUBLISH")
+		}
+		rest = rest[2:]
+	}
+	return mqttMessage{Topic: topic, Payload: rest, Retain: kind&0x01 != 0}, nil
+}
+
+func (c *mqttConn) write(kind byte, body []byte) error {
+
+	packet := []byte{kind}
+	packet = appendLength(packet, len(body))
+	packet = append(packet, body...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+func (c *mqttConn) read() (byte, []byte, error) {
+
+	kind, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// remaining length: up to four bytes, seven bits each
+	length, shift := 0, uint(0)
+	for i := 0; ; i++ {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed packet length")
+		}
+		shift += 7
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(c.r, body)
+	return kind, body, err
+}
+
+func appendLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func appendUint16(b []byte, n uint16) []byte {
+	return append(b, byte(n>>8), byte(n))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("malformed string")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("malformed string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// Returns a connection whose packets can be read from the other end of a pipe.
+func pipeMQTT() (*mqttConn, net.Conn) {
+	client, server := net.Pipe()
+	return &mqttConn{conn: client, r: bufio.NewReader(client)}, server
+}
+
+// Reads a packet as written by the client.
+func readPacket(t *testing.T, conn net.Conn) (byte, []byte) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	c := &mqttConn{r: bufio.NewReader(conn)}
+	kind, body, err := c.read()
+	if err != nil {
+		t.Fatalf("reading packet: %s", err)
+	}
+	return kind, body
+}
+
+func TestMQTTLength(t *testing.T) {
+	tests := []struct {
+		n       int
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{268435455, []byte{0xff, 0xff, 0xff, 0x7f}},
+	}
+
+	for _, test := range tests {
+		if got := appendLength(nil, test.n); !bytes.Equal(got, test.encoded) {
+			t.Errorf("appendLength(%d) = %x, want %x", test.n, got, test.encoded)
+		}
+	}
+}
+
+func TestMQTTRead(t *testing.T) {
+	body := bytes.Repeat([]byte{'x'}, 200)
+	packet := append([]byte{mqttPublish}, appendLength(nil, len(body))...)
+	packet = append(packet, body...)
+
+	c := &mqttConn{r: bufio.NewReader(bytes.NewReader(packet))}
+	kind, got, err := c.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != mqttPublish || !bytes.Equal(got, body) {
+		t.Errorf("read() = %#x, %d bytes; want %#x, %d bytes", kind, len(got), mqttPublish, len(body))
+	}
+
+	// a fifth length byte is invalid
+	c = &mqttConn{r: bufio.NewReader(bytes.NewReader([]byte{mqttPublish, 0xff, 0xff, 0xff, 0xff, 0x7f}))}
+	if _, _, err := c.read(); err == nil {
+		t.Error("read() accepted a five byte length")
+	}
+
+	// a truncated body is an error, too
+	c = &mqttConn{r: bufio.NewReader(bytes.NewReader([]byte{mqttPublish, 0x05, 'a'}))}
+	if _, _, err := c.read(); err == nil {
+		t.Error("read() accepted a truncated body")
+	}
+}
+
+func TestMQTTReadString(t *testing.T) {
+	s, rest, err := readString([]byte{0x00, 0x03, 'a', '/', 'b', 'x'})
+	if err != nil || s != "a/b" || string(rest) != "x" {
+		t.Errorf("readString() = %q, %q, %v", s, rest, err)
+	}
+
+	for _, b := range [][]byte{nil, {0x00}, {0x00, 0x02, 'a'}} {
+		if _, _, err := readString(b); err == nil {
+			t.Errorf("readString(%x) accepted a malformed string", b)
+		}
+	}
+}
+
+func TestMQTTParsePublish(t *testing.T) {
+	tests := []struct {
+		name string
+		kind byte
+		body []byte
+		want mqttMessage
+	}{
+		{
+			"QoS 0",
+			mqttPublish,
+			[]byte{0x00, 0x01, 't', '2'},
+			mqttMessage{Topic: "t", Payload: []byte("2")},
+		},
+		{
+			"retained",
+			mqttPublish | 0x01,
+			[]byte{0x00, 0x01, 't', '2'},
+			mqttMessage{Topic: "t", Payload: []byte("2"), Retain: true},
+		},
+		{
+			"QoS 1 with packet ID",
+			mqttPublish | 0x02,
+			[]byte{0x00, 0x01, 't', 0x00, 0x07, '2'},
+			mqttMessage{Topic: "t", Payload: []byte("2")},
+		},
+		{
+			"empty payload",
+			mqttPublish,
+			[]byte{0x00, 0x01, 't'},
+			mqttMessage{Topic: "t", Payload: []byte{}},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := parsePublish(test.kind, test.body)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got.Topic != test.want.Topic || !bytes.Equal(got.Payload, test.want.Payload) ||
+			got.Retain != test.want.Retain {
+			t.Errorf("%s: parsePublish() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	if _, err := parsePublish(mqttPublish|0x02, []byte{0x00, 0x01, 't', 0x00}); err == nil {
+		t.Error("parsePublish() accepted a truncated packet ID")
+	}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	c, server := pipeMQTT()
+	defer c.conn.Close()
+	defer server.Close()
+
+	go c.Publish("a/b", []byte("1.50"), true)
+	kind, body := readPacket(t, server)
+
+	if kind != mqttPublish|0x01 {
+		t.Errorf("kind = %#x, want retained PUBLISH", kind)
+	}
+	msg, err := parsePublish(kind, body)
+	if err != nil || msg.Topic != "a/b" || string(msg.Payload) != "1.50" || !msg.Retain {
+		t.Errorf("published %+v, %v", msg, err)
+	}
+}
+
+func TestMQTTSubscribe(t *testing.T) {
+	c, server := pipeMQTT()
+	defer c.conn.Close()
+	defer server.Close()
+
+	go c.Subscribe("strichliste/+/buy/+")
+	kind, body := readPacket(t, server)
+
+	want := []byte{0x00, 0x01, 0x00, 0x13}
+	want = append(want, "strichliste/+/buy/+"...)
+	want = append(want, 0x00)
+	if kind != mqttSubscribe || !bytes.Equal(body, want) {
+		t.Errorf("SUBSCRIBE = %#x %x, want %#x %x", kind, body, mqttSubscribe, want)
+	}
+}
+
+func TestMQTTConnect(t *testing.T) {
+	c, server := pipeMQTT()
+	defer c.conn.Close()
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.connect(&mqttOptions{
+			ClientID:  "id",
+			Username:  "u",
+			Password:  "p",
+			KeepAlive: 60 * time.Second,
+		})
+	}()
+
+	kind, body := readPacket(t, server)
+	want := []byte{
+		0x00, 0x04, 'M', 'Q', 'T', 'T', 4,
+		0xc2,       // username, password, clean session
+		0x00, 0x3c, // keep alive
+		0x00, 0x02, 'i', 'd',
+		0x00, 0x01, 'u',
+		0x00, 0x01, 'p',
+	}
+	if kind != mqttConnect || !bytes.Equal(body, want) {
+		t.Errorf("CONNECT = %#x %x, want %#x %x", kind, body, mqttConnect, want)
+	}
+
+	// refuse the connection: bad username or password
+	server.Write([]byte{mqttConnack, 0x02, 0x00, 0x04})
+	if err := <-errs; err == nil {
+		t.Error("connect() ignored the refusal")
+	}
+}
+
+// Starts the read and ping loops the way dialMQTT does.
+func startMQTT(c *mqttConn, keepAlive time.Duration) {
+	c.Messages = make(chan mqttMessage, 16)
+	c.done = make(chan struct{})
+	go c.readLoop(keepAlive * 3 / 2)
+	go c.pingLoop(keepAlive / 2)
+}
+
+func TestMQTTKeepAlive(t *testing.T) {
+	c, server := pipeMQTT()
+	defer server.Close()
+
+	// a broker that answers pings keeps the connection alive
+	go func() {
+		r := &mqttConn{r: bufio.NewReader(server)}
+		for {
+			kind, _, err := r.read()
+			if err != nil {
+				return
+			}
+			if kind == mqttPingreq {
+				server.Write([]byte{mqttPingresp, 0x00})
+			}
+		}
+	}()
+
+	startMQTT(c, 40*time.Millisecond)
+	select {
+	case <-c.Messages:
+		t.Fatalf("connection lost: %v", c.Err())
+	case <-time.After(200 * time.Millisecond):
+	}
+	c.conn.Close()
+}
+
+func TestMQTTKeepAliveTimeout(t *testing.T) {
+	c, server := pipeMQTT()
+	defer server.Close()
+
+	// a half-open connection: pings go out, but nothing comes back
+	go io.Copy(ioutil.Discard, server)
+
+	startMQTT(c, 40*time.Millisecond)
+	select {
+	case _, ok := <-c.Messages:
+		if ok {
+			t.Fatal("got a message from a silent broker")
+		}
+		if c.Err() == nil {
+			t.Error("connection lost without an error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("silent broker went unnoticed")
+	}
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jktr/go-strichliste/schema"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Warns about balances dropping below a threshold, as configured
+// under "low-balance" in the config file:
+//
+//	"low-balance": {
+//	  "threshold": -5.00,
+//	  "users": {"alice": -10.00},
+//	  "command": "notify-send \"$STRICHLISTE_USER: $STRICHLISTE_BALANCE\"",
+//	  "webhook": "https://example.org/strichliste",
+//	  "email": {
+//	    "server": "mail.example.org:587",
+//	    "username": "...",
+//	    "password": "...",
+//	    "from": "strichliste@example.org",
+//	    "to": ["treasurer@example.org"]
+//	  }
+//	}
+//
+// Per-user thresholds take precedence over the global one. Emails go
+// to the configured recipients, or to the user if there are none.
+type lowBalanceConfig struct {
+	Threshold *float64
+	Users     map[string]float64
+	Command   string
+	Webhook   string
+	Email     struct {
+		Server   string
+		Username string
+		Password string
+		From     string
+		To       []string
+	}
+}
+
+// Sent to the webhook and the command, as JSON on stdin.
+type lowBalanceEvent struct {
+	User        *schema.User        `json:"user"`
+	Transaction *schema.Transaction `json:"transaction"`
+	Balance     float64             `json:"balance"`
+	Threshold   float64             `json:"threshold"`
+	Limit       float64             `json:"limit"`
+}
+
+// Checks whether a transaction made its issuer's balance drop below
+// their threshold, and if so, warns about it. Notifications are best
+// effort; failures are reported, but don't fail the command.
+func (c *CLI) notifyLowBalance(tx *schema.Transaction) {
+
+	var cfg lowBalanceConfig
+	err := c.Viper.UnmarshalKey("low-balance", &cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: invalid low-balance config: %s\n", err)
+		return
+	}
+
+	user := &tx.Issuer
+
+	// viper lowercases keys
+	threshold, ok := cfg.Users[strings.ToLower(user.Name)]
+	if !ok {
+		if cfg.Threshold == nil {
+			return
+		}
+		threshold = *cfg.Threshold
+	}
+
+	// reversing a transaction takes its value back
+	delta := tx.Value
+	if tx.IsReversed {
+		delta = -tx.Value
+	}
+
+	limit := CurrencyFloat64ToInt(threshold)
+	before := user.Balance - delta
+	if !(before >= limit && user.Balance < limit) {
+		return
+	}
+
+	settings, err := c.Settings()
+	if err != nil {
+		return
+	}
+
+	message := fmt.Sprintf("balance of user #%d (%s) dropped below %s: %s",
+		user.ID, user.Name, formatCurrency(settings, limit), formatCurrency(settings, user.Balance))
+	if lower := settings.Account.Limit.Lower; lower != 0 {
+		message += fmt.Sprintf(" (account limit: %s)", formatCurrency(settings, lower))
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s\n", message)
+
+	event := &lowBalanceEvent{
+		User:        user,
+		Transaction: tx,
+		Balance:     CurrencyIntToFloat64(user.Balance),
+		Threshold:   threshold,
+		Limit:       CurrencyIntToFloat64(settings.Account.Limit.Lower),
+	}
+
+	if cfg.Command != "" {
+		err = runHookCommand(cfg.Command, event, []string{
+			"STRICHLISTE_USER=" + user.Name,
+			"STRICHLISTE_USER_ID=" + strconv.Itoa(user.ID),
+			fmt.Sprintf("STRICHLISTE_BALANCE=%.2f", event.Balance),
+			fmt.Sprintf("STRICHLISTE_THRESHOLD=%.2f", event.Threshold),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: low-balance command failed: %s\n", err)
+		}
+	}
+
+	if cfg.Webhook != "" {
+		err = postLowBalanceWebhook(cfg.Webhook, event)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: low-balance webhook failed: %s\n", err)
+		}
+	}
+
+	if cfg.Email.Server != "" {
+		err = sendLowBalanceEmail(&cfg, user, message)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: low-balance email failed: %s\n", err)
+		}
+	}
+}
+
+func postLowBalanceWebhook(url string, event *lowBalanceEvent) error {
+
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded with status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func sendLowBalanceEmail(cfg *lowBalanceConfig, user *schema.User, message string) error {
+
+	to := cfg.Email.To
+	if len(to) == 0 && user.Email != nil {
+		to = []string{*user.Email}
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("no recipients for user #%d (%s)", user.ID, user.Name)
+	}
+
+	var auth smtp.Auth
+	if cfg.Email.Username != "" {
+		host := strings.Split(cfg.Email.Server, ":")[0]
+		auth = smtp.PlainAuth("", cfg.Email.Username, cfg.Email.Password, host)
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: strichliste: low balance for %s\r\n\r\n%s\r\n",
+		cfg.Email.From, strings.Join(to, ", "), user.Name, message)
+
+	return smtp.SendMail(cfg.Email.Server, auth, cfg.Email.From, to, []byte(body))
+}
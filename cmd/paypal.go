@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/jktr/go-strichliste/schema"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Deposits via PayPal: shows a payment link for the amount, grossed up
+// for the server's PayPal fee, and records the deposit once the user
+// confirms having paid. Nothing is recorded otherwise.
+func transactPaypal(cli *CLI, user *schema.User, amount int, comment string) error {
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return err
+	}
+
+	if !settings.Paypal.IsEnabled || settings.Paypal.Recipient == "" {
+		return newError(ErrorValidation, "paypal deposits are disabled")
+	}
+	if amount <= 0 {
+		return newError(ErrorValidation, "amount must be positive for paypal deposits")
+	}
+	if fee := settings.Paypal.PercentFee; fee < 0 || fee >= 100 {
+		return newError(ErrorServer, "invalid paypal fee of %d%%", fee)
+	}
+
+	// rather find out now than after paying
+	err = validateDelta(settings, user, amount)
+	if err != nil {
+		return err
+	}
+
+	gross := paypalGross(amount, settings.Paypal.PercentFee)
+	link := paypalLink(settings, gross, fmt.Sprintf("strichliste deposit for %s", user.Name))
+
+	fmt.Printf("pay %s (%s after a %d%% fee) via paypal:\n\n  %s\n\n",
+		formatCurrency(settings, gross),
+		formatCurrency(settings, amount),
+		settings.Paypal.PercentFee,
+		link,
+	)
+
+	if isTerminal(os.Stdout) {
+		code, err := qr.Encode(link, qr.L, qr.Auto)
+		if err == nil {
+			printTerminalQR(code)
+			fmt.Println()
+		}
+	}
+
+	if cli.noPrompt || !confirm(fmt.Sprintf("paid %s? record deposit of %s for %s [y/N]: ",
+		formatCurrency(settings, gross), formatCurrency(settings, amount), user.Name)) {
+		return newError(ErrorDryRun, "payment not confirmed; no deposit recorded")
+	}
+
+	if comment == "" {
+		comment = fmt.Sprintf("paypal (%s)", formatCurrency(settings, gross))
+	}
+	return transactDelta(cli, user, amount, comment)
+}
+
+// The amount to pay so that amount is left after the fee, rounded up.
+// PayPal takes its fee from what's paid, not on top of amount.
+func paypalGross(amount, percentFee int) int {
+	net := 100 - percentFee
+	return (amount*100 + net - 1) / net
+}
+
+// Links to paypal.me for account names, or to PayPal's payment
+// form for recipients given as email addresses.
+func paypalLink(settings *schema.Settings, amount int, note string) string {
+
+	recipient := settings.Paypal.Recipient
+	value := fmt.Sprintf("%.2f", CurrencyIntToFloat64(amount))
+	currency := settings.I18n.Currency.Alpha3
+
+	if !strings.Contains(recipient, "@") {
+		return fmt.Sprintf("https://paypal.me/%s/%s%s", url.PathEscape(recipient), value, currency)
+	}
+
+	query := url.Values{}
+	query.Set("cmd", "_xclick")
+	query.Set("business", recipient)
+	query.Set("amount", value)
+	query.Set("currency_code", currency)
+	query.Set("item_name", note)
+	return "https://www.paypal.com/cgi-bin/webscr?" + query.Encode()
+}
+
+// Prints a QR code with half blocks, two modules per character, in
+// black on white regardless of the terminal's colors.
+func printTerminalQR(code barcode.Barcode) {
+
+	const quiet = 2
+	size := code.Bounds().Dx()
+	dark := func(x, y int) bool {
+		x, y = x-quiet, y-quiet
+		return x >= 0 && y >= 0 && x < size && y < size && isDark(code.At(x, y))
+	}
+
+	// foreground colors the upper half, background the lower one
+	colors := map[bool]int{false: 7, true: 0}
+	for y := 0; y < size+2*quiet; y += 2 {
+		var line strings.Builder
+		for x := 0; x < size+2*quiet; x++ {
+			fmt.Fprintf(&line, "\x1b[3%d;4%dm▀", colors[dark(x, y)], colors[dark(x, y+1)])
+		}
+		fmt.Println(line.String() + "\x1b[0m")
+	}
+}
+
+// Asks a yes/no question on stderr; anything but yes is no.
+func confirm(question string) bool {
+
+	fmt.Fprint(os.Stderr, question)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jktr/go-strichliste/schema"
+	"github.com/spf13/cobra"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Receipts are printed on an ESC/POS thermal printer, as configured
+// under "receipt" in the config file:
+//
+//	"receipt": {
+//	  "printer": "/dev/usb/lp0",   // or "tcp://bar-printer:9100"
+//	  "width": 42,                 // characters per line
+//	  "header": "Hackerspace Bar"
+//	}
+//
+// Without a printer, or if printing fails, receipts are shown as
+// plain text instead.
+
+const (
+	escInit      = "\x1b@"
+	escCodepage  = "\x1bt\x13" // PC858, i.e. CP850 with €
+	escBoldOn    = "\x1bE\x01"
+	escBoldOff   = "\x1bE\x00"
+	escCenter    = "\x1ba\x01"
+	escLeft      = "\x1ba\x00"
+	escDoubleOn  = "\x1d!\x11"
+	escDoubleOff = "\x1d!\x00"
+	escFeedCut   = "\x1bd\x04\x1dV\x01" // feed 4 lines, partial cut
+)
+
+// Registers the flags of commands that can print receipts;
+// chain printReceipt after the command in CLI.wrap.
+func addReceiptFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("receipt", false, "print a receipt of the transaction")
+	cmd.Flags().String("printer", "", "device file or tcp://host[:port] of ESC/POS printer for receipts")
+}
+
+// Prints a receipt of the command's transaction, if asked to. The
+// transaction has already taken effect, so failures are only reported.
+func printReceipt(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	wanted, _ := cmd.Flags().GetBool("receipt")
+	tx, ok := cli.result.(*schema.Transaction)
+	if !wanted || !ok {
+		return nil
+	}
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return err
+	}
+
+	r := &receipt{
+		settings: settings,
+		width:    cli.Viper.GetInt("receipt.width"),
+		header:   cli.Viper.GetString("receipt.header"),
+	}
+	if r.width <= 0 {
+		r.width = 42
+	}
+
+	// not bound to the config, as several commands share the key
+	printer := cli.Viper.GetString("receipt.printer")
+	if cmd.Flags().Changed("printer") {
+		printer, _ = cmd.Flags().GetString("printer")
+	}
+	if printer != "" {
+		err := sendToPrinter(printer, r.render(tx, true))
+		if err == nil {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "Warning: printing receipt on %s failed: %s\n", printer, err)
+	}
+
+	fmt.Println()
+	os.Stdout.Write(r.render(tx, false))
+	return nil
+}
+
+type receipt struct {
+	settings *schema.Settings
+	width    int
+	header   string
+
+	buf    bytes.Buffer
+	escpos bool // emit ESC/POS commands instead of plain text
+}
+
+// Renders a transaction as ESC/POS commands, or as plain text.
+func (r *receipt) render(tx *schema.Transaction, escpos bool) []byte {
+
+	r.buf.Reset()
+	r.escpos = escpos
+
+	r.command(escInit + escCodepage)
+	if r.header != "" {
+		r.command(escCenter + escBoldOn + escDoubleOn)
+		r.center(r.header)
+		r.command(escDoubleOff + escBoldOff + escLeft)
+		r.line("")
+	}
+
+	r.line(formatTime(localTime(tx.TimeCreated)))
+	r.line(fmt.Sprintf("User: %s (#%d)", tx.Issuer.Name, tx.Issuer.ID))
+	r.rule()
+
+	switch {
+	case tx.Article != nil && tx.Quantity != nil:
+		r.columns(describeTransaction(tx), r.amount(tx.Value))
+		if *tx.Quantity != 1 {
+			r.line("  @ " + r.amount(tx.Article.Value))
+		}
+	case tx.To != nil:
+		r.columns("Transfer to "+tx.To.Name, r.amount(tx.Value))
+	case tx.From != nil:
+		r.columns("Transfer from "+tx.From.Name, r.amount(tx.Value))
+	case tx.Value >= 0:
+		r.columns("Deposit", r.amount(tx.Value))
+	default:
+		r.columns("Withdrawal", r.amount(tx.Value))
+	}
+	if tx.Comment != "" && tx.Comment != describeTransaction(tx) {
+		r.line("  " + tx.Comment)
+	}
+	r.rule()
+
+	r.command(escBoldOn)
+	r.columns("TOTAL", r.amount(tx.Value))
+	r.command(escBoldOff)
+	r.columns("NEW BALANCE", r.amount(tx.Issuer.Balance))
+	r.line("")
+	r.line(fmt.Sprintf("Transaction #%d", tx.ID))
+
+	r.command(escFeedCut)
+	return r.buf.Bytes()
+}
+
+func (r *receipt) amount(value int) string {
+	return formatCurrency(r.settings, value)
+}
+
+func (r *receipt) command(seq string) {
+	if r.escpos {
+		r.buf.WriteString(seq)
+	}
+}
+
+func (r *receipt) line(text string) {
+	if r.escpos {
+		r.buf.Write(encodeCP858(text))
+		r.buf.WriteString("\n")
+		return
+	}
+	r.buf.WriteString(text + "\n")
+}
+
+func (r *receipt) rule() {
+	r.line(strings.Repeat("-", r.width))
+}
+
+// Centers text; the printer does that itself.
+func (r *receipt) center(text string) {
+	if pad := (r.width - utf8.RuneCountInString(text)) / 2; !r.escpos && pad > 0 {
+		text = strings.Repeat(" ", pad) + text
+	}
+	r.line(text)
+}
+
+// Prints left- and right-aligned text on one line, if it fits.
+func (r *receipt) columns(left, right string) {
+	pad := r.width - utf8.RuneCountInString(left) - utf8.RuneCountInString(right)
+	if pad < 1 {
+		r.line(left)
+		left, pad = "", r.width-utf8.RuneCountInString(right)
+		if pad < 0 {
+			pad = 0
+		}
+	}
+	r.line(left + strings.Repeat(" ", pad) + right)
+}
+
+// Code page 858 equivalents of common non-ASCII characters;
+// anything else is printed as "?".
+var cp858 = map[rune]byte{
+	'€': 0xd5, 'ä': 0x84, 'ö': 0x94, 'ü': 0x81, 'Ä': 0x8e, 'Ö': 0x99,
+	'Ü': 0x9a, 'ß': 0xe1, 'é': 0x82, 'è': 0x8a, 'à': 0x85, 'ç': 0x87,
+	'ñ': 0xa4, '£': 0x9c,
+}
+
+func encodeCP858(s string) []byte {
+	var buf []byte
+	for _, c := range s {
+		switch b, ok := cp858[c]; {
+		case c < 0x80:
+			buf = append(buf, byte(c))
+		case ok:
+			buf = append(buf, b)
+		default:
+			buf = append(buf, '?')
+		}
+	}
+	return buf
+}
+
+// Writes to a device file, or to a network printer's raw port.
+func sendToPrinter(printer string, data []byte) error {
+
+	if strings.HasPrefix(printer, "tcp://") {
+		u, err := url.Parse(printer)
+		if err != nil {
+			return err
+		}
+
+		conn, err := net.DialTimeout("tcp", withDefaultPort(u.Host, "9100"), 5*time.Second)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, err = conn.Write(data)
+		return err
+	}
+
+	f, err := os.OpenFile(printer, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
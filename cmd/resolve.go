@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/jktr/go-strichliste/schema"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Users and articles may be given by ID, by exact name, or by something
+// close to the name. Candidates are ranked by how well they match; only
+// the best-ranked ones are considered. A unique candidate is selected
+// right away, otherwise the user is asked to choose on a terminal, and
+// scripts get an error listing the candidates. Unattended commands only
+// accept exact names (ignoring case), so a typo can't charge somebody else.
+
+// How well a name matches a query; lower is better.
+const (
+	matchExact = iota
+	matchFold
+	matchPrefix
+	matchSubstring
+	matchFuzzy
+	matchNone
+)
+
+type candidate struct {
+	index int // into the list the candidates were taken from
+	rank  int
+	label string
+}
+
+// Resolves a user by ID, name or approximate name.
+func resolveUser(cli *CLI, query string) (*schema.User, error) {
+	return resolveUserAmong(cli, query, activeUsers)
+}
+
+// Resolves a disabled user, e.g. to restore them; names
+// are only matched against other disabled users.
+func resolveInactiveUser(cli *CLI, query string) (*schema.User, error) {
+	return resolveUserAmong(cli, query, inactiveUsers)
+}
+
+// Resolves a user, approximate names among those kept by filter.
+func resolveUserAmong(cli *CLI, query string, filter func([]schema.User) []schema.User) (*schema.User, error) {
+
+	if query == "" {
+		return nil, newError(ErrorUsage, "no user given")
+	}
+
+	if uid, err := strconv.Atoi(query); err == nil {
+		user, _, err := cli.Client.User.Get(uid)
+		if !isNotFound(err) {
+			return user, err
+		}
+	}
+
+	user, _, err := cli.Client.User.GetByName(query)
+	if !isNotFound(err) {
+		return user, err
+	}
+
+	users, err := listUsers(cli)
+	if err != nil {
+		return nil, err
+	}
+	users = filter(users)
+
+	var candidates []candidate
+	for i, user := range users {
+		candidates = append(candidates, candidate{
+			index: i,
+			rank:  matchRank(query, user.Name),
+			label: fmt.Sprintf("#%03d %s", user.ID, user.Name),
+		})
+	}
+
+	i, err := chooseCandidate(cli, "user", query, candidates)
+	if err != nil {
+		return nil, err
+	}
+	return &users[i], nil
+}
+
+// Resolves an article by ID, barcode, name or approximate name.
+func resolveArticle(cli *CLI, query string) (*schema.Article, error) {
+	return resolveArticleAmong(cli, query, activeArticles)
+}
+
+// Resolves a disabled article, e.g. to restore it; barcodes and
+// names are only matched against other disabled articles.
+func resolveInactiveArticle(cli *CLI, query string) (*schema.Article, error) {
+	return resolveArticleAmong(cli, query, inactiveArticles)
+}
+
+// Resolves an article, by barcode or approximate name among those
+// kept by filter.
+func resolveArticleAmong(cli *CLI, query string, filter func([]schema.Article) []schema.Article) (*schema.Article, error) {
+
+	if query == "" {
+		return nil, newError(ErrorUsage, "no article given")
+	}
+
+	if aid, err := strconv.Atoi(query); err == nil {
+		article, _, err := cli.Client.Article.Get(aid)
+		if !isNotFound(err) {
+			return article, err
+		}
+	}
+
+	articles, err := listArticles(cli)
+	if err != nil {
+		return nil, err
+	}
+	articles = filter(articles)
+
+	for i, article := range articles {
+		if article.Barcode != nil && *article.Barcode == query {
+			return &articles[i], nil
+		}
+	}
+
+	var candidates []candidate
+	for i, article := range articles {
+		candidates = append(candidates, candidate{
+			index: i,
+			rank:  matchRank(query, article.Name),
+			label: fmt.Sprintf("#%03d %s", article.ID, article.Name),
+		})
+	}
+
+	i, err := chooseCandidate(cli, "article", query, candidates)
+	if err != nil {
+		return nil, err
+	}
+	return &articles[i], nil
+}
+
+func isNotFound(err error) bool {
+	return err != nil && classifyError(err).Kind == ErrorNotFound
+}
+
+func matchRank(query, name string) int {
+
+	if name == query {
+		return matchExact
+	}
+	if strings.EqualFold(name, query) {
+		return matchFold
+	}
+
+	q, n := strings.ToLower(query), strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(n, q):
+		return matchPrefix
+	case strings.Contains(n, q):
+		return matchSubstring
+	}
+
+	// allow roughly one typo per four characters
+	if levenshtein(q, n) <= 1+len([]rune(q))/4 {
+		return matchFuzzy
+	}
+	return matchNone
+}
+
+// Returns the index of the single best candidate, asking the
+// user to pick one if there are several.
+func chooseCandidate(cli *CLI, kind, query string, candidates []candidate) (int, error) {
+
+	best := matchNone
+	for _, c := range candidates {
+		if c.rank < best {
+			best = c.rank
+		}
+	}
+
+	if cli.exactMatch && best > matchFold {
+		return 0, newError(ErrorNotFound, "no %s is named '%s'", kind, query)
+	}
+
+	var matches []candidate
+	for _, c := range candidates {
+		if c.rank == best && best != matchNone {
+			matches = append(matches, c)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].label < matches[j].label
+	})
+
+	switch {
+	case len(matches) == 0:
+		return 0, newError(ErrorNotFound, "no %s matches '%s'", kind, query)
+	case len(matches) == 1:
+		return matches[0].index, nil
+	case !cli.noPrompt && isTerminal(os.Stdin) && isTerminal(os.Stderr):
+		return promptCandidate(kind, query, matches)
+	}
+
+	var labels []string
+	for _, c := range matches {
+		labels = append(labels, c.label)
+	}
+	return 0, newError(ErrorAmbiguous, "%s '%s' is ambiguous; candidates: %s",
+		kind, query, strings.Join(labels, ", "))
+}
+
+func promptCandidate(kind, query string, matches []candidate) (int, error) {
+
+	fmt.Fprintf(os.Stderr, "%s '%s' is ambiguous:\n", kind, query)
+	for i, c := range matches {
+		fmt.Fprintf(os.Stderr, "  %d) %s\n", i+1, c.label)
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stderr, "choose [1-%d]: ", len(matches))
+
+		line, err := in.ReadString('\n')
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return 0, newError(ErrorAmbiguous, "no %s chosen for '%s'", kind, query)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(line))
+		if err == nil && 1 <= n && n <= len(matches) {
+			return matches[n-1].index, nil
+		}
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Edit distance between two strings, in runes.
+func levenshtein(a, b string) int {
+
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
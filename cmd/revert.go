@@ -2,33 +2,54 @@ package cmd
 
 import (
 	"fmt"
+	s "github.com/jktr/go-strichliste"
+	"github.com/jktr/go-strichliste/schema"
 	"github.com/spf13/cobra"
+	"sort"
 	"strconv"
+	"time"
 )
 
 func newRevertCommand(cli *CLI) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:     "revert",
+		Use:     "revert {ID | --last [N]}",
 		Aliases: []string{"undo"},
 		Short:   "delete/reverse a transaction",
-		Args:    cobra.ExactArgs(1),
-		RunE:    cli.wrap(runRevert),
+		Args:    revertArgs,
+		RunE:    cli.wrap(runPreHooks, runRevert, runPostHooks),
+
+		ValidArgsFunction: cli.wrapCompletion(firstArg(completeTransactionIDs)),
 	}
 
 	cmd.Flags().Bool("confirm", false, "confirm deletion; dry-runs otherwise")
+	cmd.Flags().Bool("last", false, "reverse your last N transactions (default 1) instead of one by ID")
 
 	return cmd
 }
 
+// With --last, the single argument is an optional count, not an ID.
+func revertArgs(cmd *cobra.Command, args []string) error {
+	last, _ := cmd.Flags().GetBool("last")
+	if last {
+		return cobra.MaximumNArgs(1)(cmd, args)
+	}
+	return cobra.ExactArgs(1)(cmd, args)
+}
+
 func runRevert(cli *CLI, cmd *cobra.Command, args []string) error {
 
+	last, _ := cmd.Flags().GetBool("last")
+	if last {
+		return runRevertLast(cli, cmd, args)
+	}
+
 	username, _ := cmd.Flags().GetString("user")
 	txId, err := strconv.Atoi(args[0])
 	if err != nil {
 		return err
 	}
 
-	user, _, err := cli.Client.User.GetByName(username)
+	user, err := resolveUser(cli, username)
 	if err != nil {
 		return err
 	}
@@ -42,25 +63,166 @@ func runRevert(cli *CLI, cmd *cobra.Command, args []string) error {
 	}
 
 	if tx.IsReversed {
-		return fmt.Errorf("transaction is already reversed")
+		return newError(ErrorValidation, "transaction is already reversed")
 	}
 
 	if !tx.IsReversible {
-		return fmt.Errorf("transaction cannot be reversed")
+		return newError(ErrorValidation, "transaction cannot be reversed")
 	}
 
 	confirmed, _ := cmd.Flags().GetBool("confirm")
 	if !confirmed {
-		return fmt.Errorf("dry-run: would delete tx #%d with user #%d (%s)",
+		return newError(ErrorDryRun, "would delete tx #%d with user #%d (%s)",
 			tx.ID, user.ID, user.Name)
 	}
 
-	rev, _, err := context.Revert(txId)
-	if !rev.IsReversed {
-		return fmt.Errorf("failed to reverse transaction")
+	rev, err := revertTransaction(cli, context, tx.ID)
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("reversed transaction #%d\n", rev.ID)
+	cli.result = rev
+	return nil
+}
+
+func runRevertLast(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	username, _ := cmd.Flags().GetString("user")
+
+	count := 1
+	if len(args) == 1 {
+		var err error
+		count, err = strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+	}
+	if count <= 0 {
+		return newError(ErrorValidation, "must reverse at least one transaction")
+	}
+
+	user, err := resolveUser(cli, username)
+	if err != nil {
+		return err
+	}
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return err
+	}
+
+	txs, err := lastTransactions(cli, user, count)
+	if err != nil {
+		return err
+	}
+
+	confirmed, _ := cmd.Flags().GetBool("confirm")
+	if !confirmed {
+		for _, tx := range txs {
+			fmt.Printf("would delete tx #%d: %.2f%s %s\n",
+				tx.ID,
+				CurrencyIntToFloat64(tx.Value),
+				settings.I18n.Currency.Symbol,
+				describeTransaction(&tx),
+			)
+		}
+		return newError(ErrorDryRun, "would delete %d tx with user #%d (%s)",
+			len(txs), user.ID, user.Name)
+	}
+
+	context := cli.Client.Transaction.Context(user.ID)
+
+	var reversed []*schema.Transaction
+	for _, tx := range txs {
+		rev, err := revertTransaction(cli, context, tx.ID)
+		if err != nil {
+			return err
+		}
+		reversed = append(reversed, rev)
+	}
+
+	cli.result = reversed
 	return nil
+}
+
+// Returns a user's last count transactions, newest first, if they can
+// all still be reversed.
+func lastTransactions(cli *CLI, user *schema.User, count int) ([]schema.Transaction, error) {
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return nil, err
+	}
+
+	context := cli.Client.Transaction.Context(user.ID)
+
+	// reversed transactions are listed too, so fetch some extra
+	recent, _, err := context.List(&s.ListOpts{PerPage: uint(2*count + 10)})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i].ID > recent[j].ID
+	})
+
+	var txs []schema.Transaction
+	for _, tx := range recent {
+		if len(txs) == count {
+			break
+		}
+		if tx.IsReversed {
+			continue
+		}
+
+		// only consecutive transactions qualify as "the last"
+		if !tx.IsReversible {
+			break
+		}
+
+		deadline, err := reverseDeadline(settings, localTime(tx.TimeCreated))
+		if err != nil {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, newError(ErrorValidation, "transaction #%d is older than the reverse timeout (%s)",
+				tx.ID, settings.Payment.Reverse.Timeout)
+		}
+
+		txs = append(txs, tx)
+	}
+
+	if len(txs) < count {
+		return nil, newError(ErrorValidation, "user #%d (%s) has only %d reversible transaction(s)",
+			user.ID, user.Name, len(txs))
+	}
+	return txs, nil
+}
+
+func revertTransaction(cli *CLI, context *s.TransactionContext, txId int) (*schema.Transaction, error) {
+
+	rev, err := reverseTransaction(cli, context, txId)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("reversed transaction #%d\n", rev.ID)
+	return rev, nil
+}
+
+func reverseTransaction(cli *CLI, context *s.TransactionContext, txId int) (*schema.Transaction, error) {
+
+	rev, _, err := context.Revert(txId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !rev.IsReversed {
+		return nil, newError(ErrorServer, "failed to reverse transaction")
+	}
 
+	cli.restoreStock(rev)
+	cli.notifyLowBalance(rev)
+	cli.deliverWebhooks(rev)
+	return rev, nil
 }
@@ -3,7 +3,9 @@ package cmd
 import (
 	s "github.com/jktr/go-strichliste"
 	"github.com/spf13/cobra"
+	"net/http"
 	"os/user"
+	"time"
 )
 
 func NewRootCommand(cli *CLI) *cobra.Command {
@@ -11,19 +13,35 @@ func NewRootCommand(cli *CLI) *cobra.Command {
 
 		Use:               "strichliste-cli",
 		Short:             "command line interface for strichliste",
+		Long:              "command line interface for strichliste\n\n" + exitCodeHelp,
 		PersistentPreRunE: cli.wrap(initConfig, initClient),
 		RunE:              func(cmd *cobra.Command, _ []string) error { return cmd.Usage() },
+
+		// errors are reported by CLI.Execute
+		SilenceErrors: true,
+		SilenceUsage:  true,
 	}
 
 	cmd.AddCommand(
 		newDebitCommand(cli),
 		newCreditCommand(cli),
 		newRevertCommand(cli),
+		newTransactionCommand(cli),
 		newUserCommand(cli),
 		newArticleCommand(cli),
 		newBuyCommand(cli),
 		newMetricsCommand(cli),
 		newSettingsCommand(cli),
+		newShellCommand(cli),
+		newCompletionCommand(cli),
+		newServeCommand(cli),
+		newMQTTBridgeCommand(cli),
+		newWebhooksCommand(cli),
+		newWatchCommand(cli),
+		newBotCommand(cli),
+		newDepositQRCommand(cli),
+		newImportCommand(cli),
+		newStockCommand(cli),
 	)
 
 	cmd.PersistentFlags().String("config", "",
@@ -32,15 +50,40 @@ func NewRootCommand(cli *CLI) *cobra.Command {
 	user, _ := user.Current()
 	cmd.PersistentFlags().StringP("user", "u", user.Username, "your username on strichliste")
 	cli.Viper.BindPFlag("user", cmd.PersistentFlags().Lookup("user"))
+	cmd.RegisterFlagCompletionFunc("user", cli.wrapCompletion(completeUserNames))
 
 	cmd.PersistentFlags().String("api-url", "http://[::1]:8080", "strichliste api endpoint")
 	cli.Viper.BindPFlag("api-url", cmd.PersistentFlags().Lookup("api-url"))
 
+	cmd.PersistentFlags().Duration("timeout", 10*time.Second, "timeout for each API request (0 disables)")
+	cli.Viper.BindPFlag("timeout", cmd.PersistentFlags().Lookup("timeout"))
+
+	// only configurable via the config file
+	cli.Viper.SetDefault("retries", 3)
+
+	cmd.PersistentFlags().BoolP("verbose", "v", false, "log HTTP requests and responses")
+	cli.Viper.BindPFlag("verbose", cmd.PersistentFlags().Lookup("verbose"))
+
+	cmd.PersistentFlags().Bool("debug", false, "log HTTP requests and responses, including headers and bodies")
+	cli.Viper.BindPFlag("debug", cmd.PersistentFlags().Lookup("debug"))
+
+	cmd.PersistentFlags().String("log-file", "", "write HTTP logs to this file (default stderr)")
+	cli.Viper.BindPFlag("log-file", cmd.PersistentFlags().Lookup("log-file"))
+
+	cmd.PersistentFlags().StringP("output", "o", "text", "output format for errors (text|json)")
+	cli.Viper.BindPFlag("output", cmd.PersistentFlags().Lookup("output"))
+
+	markUsageErrors(cmd)
 	return cmd
 }
 
 func initConfig(cli *CLI, cmd *cobra.Command, args []string) error {
 
+	// commands run from within the shell reuse its setup
+	if cli.Client != nil {
+		return nil
+	}
+
 	configFile, _ := cmd.Flags().GetString("config")
 	if configFile != "" {
 		cli.Viper.SetConfigFile(configFile)
@@ -56,9 +99,40 @@ func initConfig(cli *CLI, cmd *cobra.Command, args []string) error {
 }
 
 func initClient(cli *CLI, cmd *cobra.Command, args []string) error {
-	cli.Client = s.NewClient(
+	if cli.Client != nil {
+		return nil
+	}
+
+	transport := http.DefaultTransport
+
+	debug := cli.Viper.GetBool("debug")
+	if debug || cli.Viper.GetBool("verbose") {
+		logger, err := newTraceLogger(cli.Viper.GetString("log-file"))
+		if err != nil {
+			return err
+		}
+		transport = &traceTransport{
+			next:   transport,
+			log:    logger,
+			bodies: debug,
+		}
+	}
+
+	client := s.NewClient(
 		//s.WithApplication("strichliste-cli", "0.1"),
 		s.WithEndpoint(cli.Viper.GetString("api-url")),
 	)
+
+	err := setTransport(client, &retryTransport{
+		next:    transport,
+		timeout: cli.Viper.GetDuration("timeout"),
+		retries: cli.Viper.GetInt("retries"),
+		backoff: 500 * time.Millisecond,
+	})
+	if err != nil {
+		return err
+	}
+
+	cli.Client = client
 	return nil
 }
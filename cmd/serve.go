@@ -0,0 +1,338 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Permissions that can be granted to gateway callers, one per endpoint.
+var servePermissions = []string{"balance", "buy", "deposit", "undo"}
+
+// A client of the gateway, as configured under "serve.callers".
+type serveCaller struct {
+	name        string
+	Key         string
+	Permissions []string
+}
+
+func (c *serveCaller) may(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission || p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Serves a simplified HTTP API on top of the CLI's operations.
+type gateway struct {
+	cli     *CLI
+	callers []*serveCaller
+	log     *log.Logger
+}
+
+func newServeCommand(cli *CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "serve a simplified local HTTP API for other tools",
+		Long: `serve a simplified local HTTP API for other tools
+
+Callers authenticate with an API key, passed either as the X-API-Key
+header or as a bearer token, and are configured in the config file:
+
+  "serve": {
+    "listen": "127.0.0.1:8090",
+    "callers": {
+      "door-display": {"key": "...", "permissions": ["balance"]},
+      "button-box": {"key": "...", "permissions": ["buy", "undo"]}
+    }
+  }
+
+Permissions are named after the endpoints; "*" grants all of them.
+
+  GET  /balance?user=U
+  POST /buy      {"user": U, "article": A, "count": N, "comment": C}
+  POST /deposit  {"user": U, "amount": 1.50, "comment": C}
+  POST /undo     {"user": U}
+
+Users and articles are resolved like on the command line, but
+ambiguous ones are rejected rather than asked about.`,
+		Args: cobra.NoArgs,
+		RunE: cli.wrap(runServe),
+	}
+
+	cmd.Flags().String("listen", "127.0.0.1:8090", "address to listen on")
+	cli.Viper.BindPFlag("serve.listen", cmd.Flags().Lookup("listen"))
+
+	return cmd
+}
+
+func runServe(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	var configured map[string]*serveCaller
+	err := cli.Viper.UnmarshalKey("serve.callers", &configured)
+	if err != nil {
+		return newError(ErrorUsage, "invalid serve.callers in config: %s", err)
+	}
+
+	gw := &gateway{
+		cli: cli,
+		log: log.New(os.Stderr, "[serve] ", log.LstdFlags),
+	}
+
+	for name, caller := range configured {
+		if caller == nil || caller.Key == "" {
+			return newError(ErrorUsage, "caller '%s' has no key", name)
+		}
+		for _, p := range caller.Permissions {
+			if p != "*" && !containsString(servePermissions, p) {
+				return newError(ErrorUsage, "caller '%s' has unknown permission '%s'", name, p)
+			}
+		}
+		caller.name = name
+		gw.callers = append(gw.callers, caller)
+	}
+
+	if len(gw.callers) == 0 {
+		return newError(ErrorUsage, "no callers configured; see 'serve --help'")
+	}
+
+	// there's nobody to ask when things are ambiguous,
+	// nor to notice when a close match was the wrong one
+	cli.noPrompt = true
+	cli.exactMatch = true
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/balance", gw.handle("balance", http.MethodGet, gw.balance))
+	mux.HandleFunc("/buy", gw.handle("buy", http.MethodPost, gw.buy))
+	mux.HandleFunc("/deposit", gw.handle("deposit", http.MethodPost, gw.deposit))
+	mux.HandleFunc("/undo", gw.handle("undo", http.MethodPost, gw.undo))
+
+	srv := &http.Server{
+		Addr:         cli.Viper.GetString("serve.listen"),
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: time.Minute,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		done <- srv.Shutdown(ctx)
+	}()
+
+	gw.log.Printf("listening on %s", srv.Addr)
+	err = srv.ListenAndServe()
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return <-done
+}
+
+type gatewayRequest struct {
+	User    string  `json:"user"`
+	Article string  `json:"article"`
+	Count   int     `json:"count"`
+	Amount  float64 `json:"amount"`
+	Comment string  `json:"comment"`
+}
+
+// Authenticates and authorizes a request, then runs the endpoint's
+// handler and encodes its result or error as JSON.
+func (gw *gateway) handle(permission, method string,
+	f func(*gatewayRequest) (interface{}, error)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		caller := gw.authenticate(r)
+		name := "-"
+		if caller != nil {
+			name = caller.name
+		}
+
+		status, result := gw.serve(caller, permission, method, r, f)
+		gw.log.Printf("%s %s %s: %d", name, r.Method, r.URL.Path, status)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func (gw *gateway) serve(caller *serveCaller, permission, method string, r *http.Request,
+	f func(*gatewayRequest) (interface{}, error)) (int, interface{}) {
+
+	switch {
+	case caller == nil:
+		return gatewayError(http.StatusUnauthorized, "unauthorized", "missing or unknown API key")
+	case !caller.may(permission):
+		return gatewayError(http.StatusForbidden, "forbidden",
+			fmt.Sprintf("caller '%s' may not %s", caller.name, permission))
+	case r.Method != method:
+		return gatewayError(http.StatusMethodNotAllowed, "usage",
+			fmt.Sprintf("use %s for %s", method, r.URL.Path))
+	}
+
+	req := &gatewayRequest{
+		User:  r.URL.Query().Get("user"),
+		Count: 1,
+	}
+	if r.Method == http.MethodPost {
+		dec := json.NewDecoder(io.LimitReader(r.Body, 64<<10))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(req); err != nil {
+			return gatewayError(http.StatusBadRequest, "usage",
+				fmt.Sprintf("invalid request body: %s", err))
+		}
+	}
+
+	result, err := f(req)
+	if err != nil {
+		e := classifyError(err)
+		return gatewayError(errorStatus(e.Kind), string(e.Kind), e.Error())
+	}
+	return http.StatusOK, result
+}
+
+// Looks up the caller by the key in the X-API-Key or Authorization header.
+func (gw *gateway) authenticate(r *http.Request) *serveCaller {
+
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key == "" {
+		return nil
+	}
+
+	var found *serveCaller
+	for _, caller := range gw.callers {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(caller.Key)) == 1 {
+			found = caller
+		}
+	}
+	return found
+}
+
+func gatewayError(status int, kind, message string) (int, interface{}) {
+	return status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"kind":    kind,
+			"message": message,
+		},
+	}
+}
+
+// Maps kinds of errors to HTTP status codes, like exitCodes does for
+// exit codes.
+func errorStatus(kind ErrorKind) int {
+	switch kind {
+	case ErrorUsage:
+		return http.StatusBadRequest
+	case ErrorNotFound:
+		return http.StatusNotFound
+	case ErrorAmbiguous:
+		return http.StatusConflict
+	case ErrorValidation, ErrorLimit:
+		return http.StatusUnprocessableEntity
+	case ErrorNetwork, ErrorServer:
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// What /balance tells about a user; callers with only that permission,
+// like a door display, have no business knowing their email.
+type gatewayUser struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Balance  int    `json:"balance"` // in cents, as in the API
+	IsActive bool   `json:"active"`
+}
+
+func (gw *gateway) balance(req *gatewayRequest) (interface{}, error) {
+
+	user, err := resolveUser(gw.cli, req.User)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"user": &gatewayUser{
+		ID:       user.ID,
+		Name:     user.Name,
+		Balance:  user.Balance,
+		IsActive: user.IsActive,
+	}}, nil
+}
+
+func (gw *gateway) buy(req *gatewayRequest) (interface{}, error) {
+
+	user, err := resolveUser(gw.cli, req.User)
+	if err != nil {
+		return nil, err
+	}
+
+	article, err := resolveArticle(gw.cli, req.Article)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := purchase(gw.cli, user, article, req.Count, req.Comment)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"transaction": tx}, nil
+}
+
+func (gw *gateway) deposit(req *gatewayRequest) (interface{}, error) {
+
+	amount := CurrencyFloat64ToInt(req.Amount)
+	if amount <= 0 {
+		return nil, newError(ErrorValidation, "amount must be positive")
+	}
+
+	user, err := resolveUser(gw.cli, req.User)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := createDelta(gw.cli, user, amount, req.Comment)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"transaction": tx}, nil
+}
+
+func (gw *gateway) undo(req *gatewayRequest) (interface{}, error) {
+
+	user, err := resolveUser(gw.cli, req.User)
+	if err != nil {
+		return nil, err
+	}
+
+	txs, err := lastTransactions(gw.cli, user, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	rev, err := reverseTransaction(gw.cli, gw.cli.Client.Transaction.Context(user.ID), txs[0].ID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"transaction": rev}, nil
+}
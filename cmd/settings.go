@@ -16,10 +16,12 @@ func newSettingsCommand(cli *CLI) *cobra.Command {
 
 func runSettings(cli *CLI, cmd *cobra.Command, args []string) error {
 
+	// always fetch current settings, and refresh the cache while at it
 	s, _, err := cli.Client.Settings.Get()
 	if err != nil {
 		return err
 	}
+	cli.settings = s
 
 	// XXX incomplete listing of settings
 
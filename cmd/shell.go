@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/peterh/liner"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Interactive session state, shared by all commands run from the shell.
+type shell struct {
+	cli  *CLI
+	line *liner.State
+	user string // sticky user, replaces --user
+}
+
+func newShellCommand(cli *CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:     "shell",
+		Aliases: []string{"repl"},
+		Short:   "start an interactive shell",
+		Long: `start an interactive shell
+
+Any command can be run from within the shell, without the
+"strichliste-cli" prefix. There are also a few builtins:
+
+  use [name]  show or change the current user (replaces --user)
+  exit, quit  leave the shell (as does ^D)`,
+		Args: cobra.NoArgs,
+		RunE: cli.wrap(runShell),
+	}
+}
+
+func runShell(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	username, _ := cmd.Flags().GetString("user")
+
+	sh := &shell{
+		cli:  cli,
+		line: liner.NewLiner(),
+		user: username,
+	}
+	defer sh.line.Close()
+
+	sh.line.SetCtrlCAborts(true)
+	sh.line.SetWordCompleter(sh.complete)
+
+	historyFile, err := statePath("history")
+	if err != nil {
+		return err
+	}
+
+	if f, err := os.Open(historyFile); err == nil {
+		sh.line.ReadHistory(f)
+		f.Close()
+	}
+
+	defer func() {
+		if f, err := os.Create(historyFile); err == nil {
+			sh.line.WriteHistory(f)
+			f.Close()
+		}
+	}()
+
+	for {
+		input, err := sh.line.Prompt(fmt.Sprintf("strichliste (%s)> ", sh.user))
+		if err == liner.ErrPromptAborted {
+			continue
+		} else if err == io.EOF {
+			fmt.Println()
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		words, err := splitWords(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+		sh.line.AppendHistory(input)
+
+		if sh.exec(words) {
+			return nil
+		}
+	}
+}
+
+// Runs a single line of input; returns true if the shell should exit.
+func (sh *shell) exec(words []string) bool {
+
+	switch words[0] {
+	case "exit", "quit":
+		return true
+
+	case "use":
+		if len(words) == 1 {
+			fmt.Printf("current user: %s\n", sh.user)
+			return false
+		}
+
+		user, err := resolveUser(sh.cli, words[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return false
+		}
+
+		sh.user = user.Name
+		fmt.Printf("now acting as user #%d (%s)\n", user.ID, user.Name)
+		return false
+	}
+
+	root := sh.cli.RootCommand
+
+	if cmd, _, err := root.Find(words); err == nil && cmd.Name() == "shell" {
+		fmt.Fprintln(os.Stderr, "Error: already running a shell")
+		return false
+	}
+
+	// flags keep their values between executions, so
+	// reset them before applying the current user
+	resetFlags(root)
+	root.PersistentFlags().Lookup("user").Value.Set(sh.user)
+
+	// errors are reported, but don't end the shell
+	root.SetArgs(words)
+	sh.cli.Execute()
+
+	// commands may have changed names, so refetch when needed
+	invalidateCompletions()
+	return false
+}
+
+// Resets all flags of cmd and its subcommands to their defaults.
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		f.Value.Set(f.DefValue)
+		f.Changed = false
+	}
+
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+
+	for _, c := range cmd.Commands() {
+		resetFlags(c)
+	}
+}
+
+// Completes the word under the cursor with subcommands, flags, and
+// user or article names, depending on the preceding words.
+func (sh *shell) complete(line string, pos int) (string, []string, string) {
+
+	head, tail := line[:pos], line[pos:]
+	start := strings.LastIndex(head, " ") + 1
+	prefix := strings.TrimLeft(head[start:], `'"`)
+	words := strings.Fields(head[:start])
+
+	var candidates []string
+	switch {
+	case len(words) == 0:
+		candidates = append(commandNames(sh.cli.RootCommand), "use", "exit", "quit")
+	case words[0] == "use":
+		candidates = sh.values(completeUserNames)
+	default:
+		candidates = sh.candidates(words, prefix)
+	}
+
+	var completions []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			completions = append(completions, quoteWord(c))
+		}
+	}
+	sort.Strings(completions)
+
+	return head[:start], completions, tail
+}
+
+func (sh *shell) candidates(words []string, prefix string) []string {
+
+	cmd, _, err := sh.cli.RootCommand.Find(words)
+	if err != nil {
+		return nil
+	}
+
+	// complete the value of the preceding flag
+	switch words[len(words)-1] {
+	case "--user", "-u", "--from", "--to":
+		return sh.values(completeUserNames)
+	case "--article", "-a":
+		if cmd.Name() == "buy" {
+			return sh.values(completeArticleIDs)
+		}
+	}
+
+	if strings.HasPrefix(prefix, "-") {
+		return flagNames(cmd)
+	}
+
+	switch cmd.Name() {
+	case "user":
+		return append(commandNames(cmd), sh.values(completeUserNames)...)
+	case "article":
+		return append(commandNames(cmd), sh.values(completeArticleNames)...)
+	}
+	return commandNames(cmd)
+}
+
+// Completes names via the (cached) shell completions,
+// albeit without their descriptions.
+func (sh *shell) values(f func(*CLI, *cobra.Command, []string) ([]string, error)) []string {
+	completions, err := f(sh.cli, sh.cli.RootCommand, nil)
+	if err != nil {
+		return nil
+	}
+	return completionValues(completions)
+}
+
+func commandNames(cmd *cobra.Command) []string {
+	var names []string
+	for _, c := range cmd.Commands() {
+		if c.IsAvailableCommand() {
+			names = append(names, c.Name())
+		}
+	}
+	return names
+}
+
+func flagNames(cmd *cobra.Command) []string {
+	var names []string
+	add := func(f *pflag.Flag) {
+		names = append(names, "--"+f.Name)
+	}
+	cmd.Flags().VisitAll(add)
+	cmd.InheritedFlags().VisitAll(add)
+	return names
+}
+
+// Splits a line into words, similar to a POSIX shell, but without
+// any expansions. Supports single/double quotes and backslash escapes.
+func splitWords(line string) ([]string, error) {
+
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord, escaped := false, false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// Quotes a word so that splitWords yields it unchanged.
+func quoteWord(word string) string {
+	if !strings.ContainsAny(word, " \t'\"\\") {
+		return word
+	}
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,380 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	s "github.com/jktr/go-strichliste"
+	"github.com/jktr/go-strichliste/schema"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The server doesn't know about stock, so it's tracked locally, per
+// server and article ID. Purchases made with this CLI (including its
+// gateway, bot and MQTT bridge) decrement it, and reverting them
+// restores it; others don't.
+type stockLevel struct {
+	Count     int       `json:"count"`
+	Threshold int       `json:"threshold"` // warn when dropping below; 0 for never
+	Updated   time.Time `json:"updated"`
+	Replaces  []int     `json:"replaces,omitempty"` // IDs of the article's earlier versions
+}
+
+// Stock levels by server, then article ID.
+type stockLedger map[string]map[string]*stockLevel
+
+const stockFile = "stock.json"
+
+func newStockCommand(cli *CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stock",
+		Short: "track how much of each article is in stock",
+		Args:  cobra.NoArgs,
+		RunE:  func(cmd *cobra.Command, _ []string) error { return cmd.Usage() },
+	}
+
+	add := &cobra.Command{
+		Use:   "add <article> <count>",
+		Short: "add to an article's stock (or remove, with a negative count)",
+		Args:  cobra.ExactArgs(2),
+		RunE:  cli.wrap(runStockAdd),
+
+		ValidArgsFunction: cli.wrapCompletion(firstArg(completeArticleNames)),
+	}
+
+	add.Flags().Int("threshold", 0, `warn when the stock drops below this (0 disables; default: config's "stock.threshold")`)
+
+	show := &cobra.Command{
+		Use:   "show [article...]",
+		Short: "show stock levels and how long they'll last (default: of all tracked articles)",
+		Args:  cobra.ArbitraryArgs,
+		RunE:  cli.wrap(runStockShow),
+
+		ValidArgsFunction: cli.wrapCompletion(completeArticleNames),
+	}
+
+	show.Flags().Int("days", 14, "estimate sales per day from this many past days")
+
+	cmd.AddCommand(add, show)
+	return cmd
+}
+
+func runStockAdd(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return newError(ErrorUsage, "invalid count '%s'", args[1])
+	}
+
+	article, err := resolveArticle(cli, args[0])
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockState(stockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ledger, err := loadStockLedger()
+	if err != nil {
+		return err
+	}
+
+	level := ledger.level(cli, article)
+	if level == nil {
+		level = &stockLevel{Threshold: cli.Viper.GetInt("stock.threshold")}
+		ledger.server(cli)[strconv.Itoa(article.ID)] = level
+	}
+
+	if cmd.Flags().Changed("threshold") {
+		level.Threshold, _ = cmd.Flags().GetInt("threshold")
+	}
+
+	level.Count += count
+	level.Updated = time.Now()
+
+	err = saveStockLedger(ledger)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("stock of article #%d (%s): %d\n", article.ID, article.Name, level.Count)
+	return nil
+}
+
+func runStockShow(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	days, _ := cmd.Flags().GetInt("days")
+	if days <= 0 {
+		return newError(ErrorValidation, "days must be positive")
+	}
+
+	ledger, err := loadStockLedger()
+	if err != nil {
+		return err
+	}
+
+	var articles []*schema.Article
+	if len(args) == 0 {
+		var ids []int
+		for id := range ledger.server(cli) {
+			if n, err := strconv.Atoi(id); err == nil {
+				ids = append(ids, n)
+			}
+		}
+		sort.Ints(ids)
+
+		for _, id := range ids {
+			article, _, err := cli.Client.Article.Get(id)
+			if err != nil {
+				return err
+			}
+			articles = append(articles, article)
+		}
+	}
+	for _, arg := range args {
+		article, err := resolveArticle(cli, arg)
+		if err != nil {
+			return err
+		}
+		articles = append(articles, article)
+	}
+
+	if len(articles) == 0 {
+		fmt.Println("no articles in stock; see stock add")
+		return nil
+	}
+
+	sold, err := recentSales(cli, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return err
+	}
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "ID\tNAME\tSTOCK\tTHRESHOLD\tSOLD/DAY\tDAYS LEFT")
+	for _, article := range articles {
+		level := ledger.level(cli, article)
+		if level == nil {
+			fmt.Fprintf(w, "%d\t%s\t-\t-\t-\t-\n", article.ID, article.Name)
+			continue
+		}
+
+		// including sales of the article's previous versions
+		n := 0
+		for a := article; a != nil; a = a.Precursor {
+			n += sold[a.ID]
+		}
+
+		perDay := float64(n) / float64(days)
+		left := "-"
+		if level.Count <= 0 {
+			left = "empty"
+		} else if perDay > 0 {
+			left = strconv.Itoa(int(math.Floor(float64(level.Count) / perDay)))
+		}
+
+		threshold := "-"
+		if level.Threshold > 0 {
+			threshold = strconv.Itoa(level.Threshold)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%.1f\t%s\n",
+			article.ID, article.Name, level.Count, threshold, perDay, left)
+	}
+	return w.Flush()
+}
+
+// Counts articles sold since the given time, by article ID.
+func recentSales(cli *CLI, since time.Time) (map[int]int, error) {
+
+	sold := map[int]int{}
+	first := 0
+	for page := uint(1); ; page++ {
+		batch, _, err := cli.Client.Transaction.List(&s.ListOpts{Page: page, PerPage: listPageSize})
+		if err != nil {
+			return nil, err
+		}
+
+		// a server ignoring the page would repeat it forever
+		if len(batch) == 0 || batch[0].ID == first {
+			return sold, nil
+		}
+		first = batch[0].ID
+
+		// transactions are listed newest first
+		older := false
+		for _, tx := range batch {
+			if localTime(tx.TimeCreated).Before(since) {
+				older = true
+				continue
+			}
+			if tx.Article != nil && tx.Quantity != nil && !tx.IsReversed {
+				sold[tx.Article.ID] += *tx.Quantity
+			}
+		}
+
+		if older || len(batch) < listPageSize {
+			return sold, nil
+		}
+	}
+}
+
+// Takes purchased articles out of stock, if tracked, and warns if that
+// makes the stock drop below its threshold. Failures are only reported,
+// as the purchase has already happened.
+func (c *CLI) decrementStock(article *schema.Article, count int) {
+
+	before, level, err := c.adjustStock(article, -count)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: can't update stock: %s\n", err)
+		return
+	}
+
+	if level != nil && level.droppedBelowThreshold(before) {
+		fmt.Fprintf(os.Stderr, "Warning: stock of article #%d (%s) dropped below %d: %d left\n",
+			article.ID, article.Name, level.Threshold, level.Count)
+	}
+}
+
+// Puts the articles of a reversed purchase back into stock, if tracked.
+func (c *CLI) restoreStock(rev *schema.Transaction) {
+
+	if rev.Article == nil || rev.Quantity == nil {
+		return
+	}
+
+	_, _, err := c.adjustStock(rev.Article, *rev.Quantity)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: can't update stock: %s\n", err)
+	}
+}
+
+// Changes an article's stock by delta, if it's tracked, and returns
+// the count before along with the new level (nil if untracked).
+func (c *CLI) adjustStock(article *schema.Article, delta int) (int, *stockLevel, error) {
+
+	unlock, err := lockState(stockFile)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer unlock()
+
+	ledger, err := loadStockLedger()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	level := ledger.level(c, article)
+	if level == nil {
+		return 0, nil, nil
+	}
+
+	before := level.Count
+	level.Count += delta
+	level.Updated = time.Now()
+
+	return before, level, saveStockLedger(ledger)
+}
+
+// Whether the stock just dropped below the threshold, having been at
+// before; it's only reported once, not on every further purchase.
+func (l *stockLevel) droppedBelowThreshold(before int) bool {
+	return l.Threshold > 0 && before >= l.Threshold && l.Count < l.Threshold
+}
+
+func (l stockLedger) server(cli *CLI) map[string]*stockLevel {
+	url := cli.Viper.GetString("api-url")
+	if l[url] == nil {
+		l[url] = map[string]*stockLevel{}
+	}
+	return l[url]
+}
+
+// Returns an article's stock level, or nil if it isn't tracked.
+// Updating an article may give it a new ID, so its stock is
+// carried over from its precursors. Their IDs are remembered, as
+// older purchases may still be reverted.
+func (l stockLedger) level(cli *CLI, article *schema.Article) *stockLevel {
+
+	levels := l.server(cli)
+	id := strconv.Itoa(article.ID)
+
+	for a := article; a != nil; a = a.Precursor {
+		level, ok := levels[strconv.Itoa(a.ID)]
+		if !ok {
+			continue
+		}
+		if a.ID != article.ID {
+			for p := article.Precursor; p != a.Precursor; p = p.Precursor {
+				level.Replaces = append(level.Replaces, p.ID)
+			}
+			delete(levels, strconv.Itoa(a.ID))
+			levels[id] = level
+		}
+		return level
+	}
+
+	// the article may have been replaced since
+	for _, level := range levels {
+		for _, replaced := range level.Replaces {
+			if replaced == article.ID {
+				return level
+			}
+		}
+	}
+	return nil
+}
+
+// Reads the ledger; a missing one is empty. Unlike other state, it
+// can't just be started over, so an unreadable one is an error rather
+// than something to overwrite.
+func loadStockLedger() (stockLedger, error) {
+
+	ledger := stockLedger{}
+	path, err := statePath(stockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(buf, &ledger)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s: %s", path, err)
+	}
+	return ledger, nil
+}
+
+func saveStockLedger(ledger stockLedger) error {
+
+	path, err := statePath(stockFile)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.MarshalIndent(ledger, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write atomically, so an interrupted write can't lose the stock
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	s "github.com/jktr/go-strichliste"
+	"github.com/jktr/go-strichliste/schema"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newStockTestCLI(url string) *CLI {
+	cli := &CLI{Viper: viper.New()}
+	cli.Viper.Set("api-url", url)
+	return cli
+}
+
+func TestStockLedgerLevel(t *testing.T) {
+	v1 := &schema.Article{ID: 1}
+	v2 := &schema.Article{ID: 2, Precursor: v1}
+	v3 := &schema.Article{ID: 3, Precursor: v2}
+	other := &schema.Article{ID: 4}
+
+	tests := []struct {
+		name    string
+		tracked string // ID the stock is tracked under
+		article *schema.Article
+		want    bool   // whether a level is found
+		key     string // ID it's tracked under afterwards
+	}{
+		{"tracked", "1", v1, true, "1"},
+		{"untracked", "1", other, false, "1"},
+		{"updated", "1", v2, true, "2"},
+		{"updated twice", "1", v3, true, "3"},
+		{"updated in between", "2", v3, true, "3"},
+		{"precursor of tracked", "2", v1, false, "2"},
+	}
+
+	for _, test := range tests {
+		cli := newStockTestCLI("http://a")
+		level := &stockLevel{Count: 5}
+		ledger := stockLedger{"http://a": {test.tracked: level}}
+
+		got := ledger.level(cli, test.article)
+		if (got != nil) != test.want || (got != nil && got != level) {
+			t.Errorf("%s: level() = %v, want found: %v", test.name, got, test.want)
+		}
+		if ledger["http://a"][test.key] != level || len(ledger["http://a"]) != 1 {
+			t.Errorf("%s: ledger = %v, want the level under %s", test.name, ledger["http://a"], test.key)
+		}
+	}
+}
+
+func TestStockLedgerLevelReplaced(t *testing.T) {
+	cli := newStockTestCLI("http://a")
+	v1 := &schema.Article{ID: 1}
+	v2 := &schema.Article{ID: 2, Precursor: v1}
+	v3 := &schema.Article{ID: 3, Precursor: v2}
+
+	level := &stockLevel{Count: 5}
+	ledger := stockLedger{"http://a": {"1": level}}
+
+	// seeing the newest version moves the stock to it...
+	if ledger.level(cli, v3) != level {
+		t.Fatal("stock wasn't carried over")
+	}
+
+	// ...but purchases of older versions still find it, e.g. to revert them
+	for _, article := range []*schema.Article{v1, v2, v3} {
+		if ledger.level(cli, article) != level {
+			t.Errorf("no level for article #%d", article.ID)
+		}
+	}
+	if len(ledger["http://a"]) != 1 {
+		t.Errorf("ledger = %v, want a single level", ledger["http://a"])
+	}
+}
+
+func TestStockLedgerServers(t *testing.T) {
+	article := &schema.Article{ID: 1}
+	a, b := &stockLevel{Count: 1}, &stockLevel{Count: 2}
+	ledger := stockLedger{
+		"http://a": {"1": a},
+		"http://b": {"1": b},
+	}
+
+	if got := ledger.level(newStockTestCLI("http://a"), article); got != a {
+		t.Errorf("level() on server a = %v, want %v", got, a)
+	}
+	if got := ledger.level(newStockTestCLI("http://b"), article); got != b {
+		t.Errorf("level() on server b = %v, want %v", got, b)
+	}
+	if got := ledger.level(newStockTestCLI("http://c"), article); got != nil {
+		t.Errorf("level() on server c = %v, want nil", got)
+	}
+}
+
+func TestStockDroppedBelowThreshold(t *testing.T) {
+	tests := []struct {
+		threshold, before, count int
+		want                     bool
+	}{
+		{5, 6, 4, true},
+		{5, 5, 4, true},
+		{5, 6, 5, false}, // still at the threshold
+		{5, 4, 3, false}, // already below; warned before
+		{5, 2, 8, false}, // restocked
+		{0, 1, -1, false},
+	}
+
+	for _, test := range tests {
+		level := &stockLevel{Count: test.count, Threshold: test.threshold}
+		if got := level.droppedBelowThreshold(test.before); got != test.want {
+			t.Errorf("threshold %d, %d -> %d: droppedBelowThreshold() = %v, want %v",
+				test.threshold, test.before, test.count, got, test.want)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Returns a client that serves the given pages of transactions,
+// recording which pages were requested.
+func newPagedClient(t *testing.T, pages [][]string, requested *[]int) *s.Client {
+	client := s.NewClient(s.WithEndpoint("http://strichliste.invalid/api"))
+	err := setTransport(client, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+		*requested = append(*requested, page)
+
+		body := `{"transactions": []}`
+		if page >= 1 && page <= len(pages) {
+			body = `{"transactions": [` + strings.Join(pages[page-1], ",") + `]}`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Request:    req,
+		}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func saleJSON(id, article, quantity int, created time.Time, reversed bool) string {
+	return fmt.Sprintf(`{"id": %d, "quantity": %d, "articleId": {"id": %d}, "created": "%s", "deleted": %t}`,
+		id, quantity, article, created.Format(schema.TimestampLayout), reversed)
+}
+
+func TestRecentSales(t *testing.T) {
+	now := time.Now()
+	since := now.Add(-24 * time.Hour)
+
+	// a full first page, newest first, then one crossing since
+	var first []string
+	for i := 0; i < listPageSize; i++ {
+		first = append(first, saleJSON(1000-i, 1, 1, now.Add(-time.Duration(i)*time.Minute), i == 0))
+	}
+	second := []string{
+		saleJSON(900, 2, 3, since.Add(time.Hour), false),
+		saleJSON(899, 1, 2, since.Add(-time.Hour), false),
+		saleJSON(898, 2, 5, since.Add(-2*time.Hour), false),
+	}
+	third := []string{saleJSON(897, 2, 7, since.Add(-3*time.Hour), false)}
+
+	var requested []int
+	cli := newStockTestCLI("http://a")
+	cli.Client = newPagedClient(t, [][]string{first, second, third}, &requested)
+
+	sold, err := recentSales(cli, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first sale was reversed
+	if sold[1] != listPageSize-1 || sold[2] != 3 || len(sold) != 2 {
+		t.Errorf("recentSales() = %v, want map[1:%d 2:3]", sold, listPageSize-1)
+	}
+	if len(requested) != 2 {
+		t.Errorf("requested pages %v, want [1 2]", requested)
+	}
+}
+
+func TestRecentSalesRepeatedPage(t *testing.T) {
+	now := time.Now()
+
+	var page []string
+	for i := 0; i < listPageSize; i++ {
+		page = append(page, saleJSON(1000-i, 1, 1, now, false))
+	}
+
+	// a server ignoring the page parameter
+	var requested []int
+	cli := newStockTestCLI("http://a")
+	cli.Client = newPagedClient(t, [][]string{page, page, page, page}, &requested)
+
+	sold, err := recentSales(cli, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sold[1] != listPageSize || len(requested) != 2 {
+		t.Errorf("recentSales() = %v after pages %v, want map[1:%d] after [1 2]",
+			sold, requested, listPageSize)
+	}
+}
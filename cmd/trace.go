@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Headers whose values are never logged.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+}
+
+// Logs each HTTP request and its response, optionally including
+// headers and bodies. Sits below retryTransport to log every attempt.
+type traceTransport struct {
+	next   http.RoundTripper
+	log    *log.Logger
+	bodies bool
+}
+
+// Returns a logger writing to the configured log file, or stderr.
+func newTraceLogger(path string) (*log.Logger, error) {
+	var w io.Writer = os.Stderr
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return log.New(w, "[http] ", log.Ldate|log.Ltime|log.Lmicroseconds), nil
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	url := req.URL.Redacted()
+	t.log.Printf("> %s %s", req.Method, url)
+
+	if t.bodies {
+		t.logHeaders(">", req.Header)
+		if req.GetBody != nil {
+			if body, err := req.GetBody(); err == nil {
+				buf, _ := ioutil.ReadAll(body)
+				t.logBody(">", buf)
+			}
+		}
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	if err != nil {
+		t.log.Printf("< %s %s failed after %s: %s", req.Method, url, elapsed, err)
+		return nil, err
+	}
+
+	t.log.Printf("< %s %s: %s (%s)", req.Method, url, resp.Status, elapsed)
+
+	if t.bodies {
+		t.logHeaders("<", resp.Header)
+
+		buf, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(buf))
+		t.logBody("<", buf)
+	}
+	return resp, nil
+}
+
+func (t *traceTransport) logHeaders(direction string, header http.Header) {
+
+	var names []string
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			value = "[redacted]"
+		}
+		t.log.Printf("%s %s: %s", direction, name, value)
+	}
+}
+
+func (t *traceTransport) logBody(direction string, body []byte) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 {
+		t.log.Printf("%s %s", direction, body)
+	}
+}
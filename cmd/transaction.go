@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/jktr/go-strichliste/schema"
+	"github.com/spf13/cobra"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func newTransactionCommand(cli *CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "tx",
+		Aliases: []string{"transaction"},
+		Short:   "inspect transactions",
+		Args:    cobra.NoArgs,
+		RunE:    func(cmd *cobra.Command, _ []string) error { return cmd.Usage() },
+	}
+
+	show := &cobra.Command{
+		Use:   "show",
+		Short: "show all details of a transaction",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cli.wrap(runTransactionShow),
+
+		ValidArgsFunction: cli.wrapCompletion(firstArg(completeTransactionIDs)),
+	}
+
+	cmd.AddCommand(show)
+	return cmd
+}
+
+func runTransactionShow(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	username, _ := cmd.Flags().GetString("user")
+	txId, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+
+	user, err := resolveUser(cli, username)
+	if err != nil {
+		return err
+	}
+
+	// XXX any valid user works, for some reason
+	tx, _, err := cli.Client.Transaction.Context(user.ID).Get(txId)
+	if err != nil {
+		return err
+	}
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("transaction #%d\n", tx.ID)
+	fmt.Printf("\tissuer: #%03d (%s)\n", tx.Issuer.ID, tx.Issuer.Name)
+	if tx.From != nil {
+		fmt.Printf("\tsender: #%03d (%s)\n", tx.From.ID, tx.From.Name)
+	}
+	if tx.To != nil {
+		fmt.Printf("\trecipient: #%03d (%s)\n", tx.To.ID, tx.To.Name)
+	}
+	if tx.Article != nil {
+		quantity := 1
+		if tx.Quantity != nil {
+			quantity = *tx.Quantity
+		}
+		fmt.Printf("\tarticle: %d x #%03d (%s)\n", quantity, tx.Article.ID, tx.Article.Name)
+	}
+	fmt.Printf("\tamount: %.2f%s\n",
+		CurrencyIntToFloat64(tx.Value),
+		settings.I18n.Currency.Symbol,
+	)
+	if tx.Comment != "" {
+		fmt.Printf("\tcomment: '%s'\n", tx.Comment)
+	}
+
+	created := localTime(tx.TimeCreated)
+	fmt.Printf("\tcreated: %s\n", created.Format(schema.TimestampLayout))
+	fmt.Printf("\treversible: %t\n", tx.IsReversible)
+	fmt.Printf("\treversed: %t\n", tx.IsReversed)
+
+	if tx.IsReversible && !tx.IsReversed {
+		fmt.Printf("\treverse window: %s\n", describeReverseWindow(settings, created))
+	}
+	return nil
+}
+
+// Briefly describes what a transaction was about.
+func describeTransaction(tx *schema.Transaction) string {
+	if tx.Article != nil && tx.Quantity != nil {
+		return fmt.Sprintf("%dx %s", *tx.Quantity, tx.Article.Name)
+	} else if tx.To != nil {
+		return fmt.Sprintf("to %s", tx.To.Name)
+	}
+	return tx.Comment
+}
+
+// Describes how much time is left to reverse a transaction
+// created at the given time, according to the server's settings.
+func describeReverseWindow(settings *schema.Settings, created time.Time) string {
+
+	if !settings.Payment.Reverse.IsEnabled {
+		return "disabled"
+	}
+
+	deadline, err := reverseDeadline(settings, created)
+	if err != nil {
+		return fmt.Sprintf("unknown (%s)", err)
+	}
+
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return "expired"
+	}
+	return fmt.Sprintf("%s remaining", remaining.Truncate(time.Second))
+}
+
+// Returns the point in time after which a transaction created at
+// the given time can no longer be reversed.
+func reverseDeadline(settings *schema.Settings, created time.Time) (time.Time, error) {
+
+	if !settings.Payment.Reverse.IsEnabled {
+		return time.Time{}, newError(ErrorValidation, "reversing transactions is disabled")
+	}
+
+	timeout, err := parseServerDuration(settings.Payment.Reverse.Timeout)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return created.Add(timeout), nil
+}
+
+// The server returns timestamps without a time zone,
+// so we assume that it shares ours.
+func localTime(ts schema.Timestamp) time.Time {
+	t := time.Time(ts)
+	if t.IsZero() {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
+
+// Parses durations in the relative format used by the server's
+// settings, e.g. "5 minute" or "1 day 12 hours".
+func parseServerDuration(s string) (time.Duration, error) {
+
+	units := map[string]time.Duration{
+		"sec":    time.Second,
+		"second": time.Second,
+		"min":    time.Minute,
+		"minute": time.Minute,
+		"hour":   time.Hour,
+		"day":    24 * time.Hour,
+		"week":   7 * 24 * time.Hour,
+	}
+
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return 0, fmt.Errorf("invalid duration '%s'", s)
+	}
+
+	var d time.Duration
+	for i := 0; i < len(fields); i += 2 {
+		n, err := strconv.Atoi(strings.TrimPrefix(fields[i], "+"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", s)
+		}
+
+		unit, ok := units[strings.TrimSuffix(fields[i+1], "s")]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration unit '%s'", fields[i+1])
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	s "github.com/jktr/go-strichliste"
+	"github.com/jktr/go-strichliste/schema"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"time"
+	"unsafe"
+)
+
+// Matches the path used to create transactions, capturing the user ID.
+var createTransactionPath = regexp.MustCompile(`/user/(\d+)/transaction$`)
+
+// Sets the http.RoundTripper used by the client, and only by the
+// client; webhooks and the like keep http.DefaultTransport.
+//
+// XXX go-strichliste doesn't allow passing a http.Client, so we set its
+// unexported one. This depends on the version pinned in go.mod, and
+// TestSetTransport fails if an update breaks it; drop this once the
+// library gains an option for it.
+func setTransport(c *s.Client, rt http.RoundTripper) error {
+	field := reflect.ValueOf(c).Elem().FieldByName("httpClient")
+	if !field.IsValid() || field.Type() != reflect.TypeOf(&http.Client{}) {
+		return errors.New("can't set the transport of go-strichliste's client")
+	}
+	field = reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+	field.Set(reflect.ValueOf(&http.Client{Transport: rt}))
+	return nil
+}
+
+// Applies timeouts to all requests and retries them with exponential
+// backoff. Only GETs are retried blindly; a failed transaction is only
+// retried once the user's history confirms that it didn't go through.
+type retryTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration // per attempt; 0 disables
+	retries int
+	backoff time.Duration // initial delay; doubles with each retry
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	if req.Method == http.MethodGet {
+		return t.retry(func() (*http.Response, error) {
+			return t.attempt(req)
+		})
+	}
+
+	if req.Method == http.MethodPost && createTransactionPath.MatchString(req.URL.Path) {
+		return t.createTransaction(req)
+	}
+
+	return t.attempt(req)
+}
+
+// Sends a request once. The response body is read before returning,
+// as the timeout would otherwise interrupt reading it later on.
+func (t *retryTransport) attempt(req *http.Request) (*http.Response, error) {
+
+	ctx, cancel := req.Context(), func() {}
+	if t.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+	}
+	defer cancel()
+
+	req = req.WithContext(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// Calls f until it succeeds, backing off between attempts. Gateway
+// errors are retried too, but API errors (which use status 500) aren't.
+func (t *retryTransport) retry(f func() (*http.Response, error)) (*http.Response, error) {
+
+	delay := t.backoff
+	for i := 0; ; i++ {
+		resp, err := f()
+		if i >= t.retries || (err == nil && !isGatewayError(resp)) {
+			return resp, err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func isGatewayError(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Creates a transaction. If the request fails in transit, the user's
+// recent transactions are checked for it before trying again. Should it
+// have gone through after all, a response is synthesized from the history.
+func (t *retryTransport) createTransaction(req *http.Request) (*http.Response, error) {
+
+	start := time.Now()
+	resp, err := t.attempt(req)
+	if err == nil || req.GetBody == nil {
+		return resp, err
+	}
+
+	var tcr schema.TransactionCreateRequest
+	body, bodyErr := req.GetBody()
+	if bodyErr == nil {
+		bodyErr = json.NewDecoder(body).Decode(&tcr)
+	}
+	if bodyErr != nil {
+		return resp, err
+	}
+
+	delay := t.backoff
+	for i := 0; i < t.retries && err != nil; i++ {
+		time.Sleep(delay)
+		delay *= 2
+
+		recent, since, listErr := t.recentTransactions(req, 10, start)
+		if listErr != nil {
+			continue // still unreachable; check again later
+		}
+
+		for _, tx := range recent {
+			if !localTime(tx.TimeCreated).Before(since) && matchesTransaction(&tx.Transaction, &tcr) {
+				return synthesizeResponse(req, tx.raw)
+			}
+		}
+
+		resp, err = t.attempt(req)
+	}
+	return resp, err
+}
+
+type rawTransaction struct {
+	schema.Transaction
+	raw json.RawMessage
+}
+
+// Lists the issuing user's most recent transactions (newest first)
+// with the same endpoint and headers as the original request. Also
+// translates start into the server's clock, using its Date header, so
+// transactions created since then can be told apart from older ones.
+func (t *retryTransport) recentTransactions(orig *http.Request, limit int, start time.Time) ([]rawTransaction, time.Time, error) {
+
+	u := *orig.URL
+	u.RawQuery = fmt.Sprintf("limit=%d", limit)
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, start, err
+	}
+	req = req.WithContext(orig.Context())
+	for _, h := range []string{"Accept", "User-Agent", "Authorization"} {
+		if v := orig.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+
+	now := time.Now()
+	resp, err := t.attempt(req)
+	if err != nil {
+		return nil, start, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, start, fmt.Errorf("listing transactions failed with status code %d", resp.StatusCode)
+	}
+
+	// the Date header only has a resolution of seconds, as do
+	// the timestamps, so allow for some leeway
+	since := start.Add(-2 * time.Second)
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		since = since.Add(date.Sub(now))
+	}
+
+	var body struct {
+		Transactions []json.RawMessage `json:"transactions"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, start, err
+	}
+
+	txs := make([]rawTransaction, len(body.Transactions))
+	for i, raw := range body.Transactions {
+		err = json.Unmarshal(raw, &txs[i].Transaction)
+		if err != nil {
+			return nil, start, err
+		}
+		txs[i].raw = raw
+	}
+	return txs, since, nil
+}
+
+func matchesTransaction(tx *schema.Transaction, tcr *schema.TransactionCreateRequest) bool {
+
+	if tx.Value != tcr.Amount || tx.Comment != tcr.Comment || tx.IsReversed {
+		return false
+	}
+
+	if tcr.Recipient != nil && (tx.To == nil || tx.To.ID != *tcr.Recipient) {
+		return false
+	}
+
+	// articles aren't always included in listings
+	if tcr.ArticleID != nil && tx.Article != nil && tx.Article.ID != *tcr.ArticleID {
+		return false
+	}
+	return true
+}
+
+func synthesizeResponse(req *http.Request, tx json.RawMessage) (*http.Response, error) {
+
+	body, err := json.Marshal(map[string]json.RawMessage{"transaction": tx})
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"bytes"
+	s "github.com/jktr/go-strichliste"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// Answers every request with the same JSON body, recording the paths.
+type stubTransport struct {
+	body  string
+	paths []string
+}
+
+func (t *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.paths = append(t.paths, req.URL.Path)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(t.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestSetTransport(t *testing.T) {
+	client := s.NewClient(s.WithEndpoint("http://strichliste.invalid/api"))
+
+	stub := &stubTransport{body: `{"user": {"id": 1, "name": "alice", "balance": 250}}`}
+	err := setTransport(client, stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, _, err := client.User.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.paths) != 1 || stub.paths[0] != "/api/user/1" {
+		t.Errorf("transport saw %v, want [/api/user/1]", stub.paths)
+	}
+	if user.Name != "alice" || user.Balance != 250 {
+		t.Errorf("Get() = %+v", user)
+	}
+}
@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	s "github.com/jktr/go-strichliste"
 	"github.com/jktr/go-strichliste/schema"
 	"github.com/spf13/cobra"
+	"net/http"
+	"net/mail"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func newUserCommand(cli *CLI) *cobra.Command {
@@ -14,13 +20,17 @@ func newUserCommand(cli *CLI) *cobra.Command {
 		Short: "interact with the user database",
 		Args:  cobra.MaximumNArgs(1),
 		RunE:  cli.wrap(runUserGet),
+
+		ValidArgsFunction: cli.wrapCompletion(firstArg(completeUserNames)),
 	}
 
+	cmd.Flags().Bool("include-inactive", false, "include disabled users in search results")
+
 	create := &cobra.Command{
 		Use:   "create",
 		Short: "open a new user account",
 		Args:  cobra.NoArgs,
-		RunE:  cli.wrap(runUserCreate),
+		RunE:  cli.wrap(runPreHooks, runUserCreate, runPostHooks),
 	}
 
 	create.Flags().String("name", "", "user's name")
@@ -30,25 +40,63 @@ func newUserCommand(cli *CLI) *cobra.Command {
 	create.Flags().Float64("balance", 0, "user's initial balance")
 
 	update := &cobra.Command{
-		Use:   "update",
-		Short: "update a user account's metadata",
-		Args:  cobra.NoArgs,
-		RunE:  cli.wrap(runUserUpdate),
+		Use:   "update [ID | name]",
+		Short: "update a user account's metadata (default: your own)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  cli.wrap(runPreHooks, runUserUpdate, runPostHooks),
+
+		ValidArgsFunction: cli.wrapCompletion(firstArg(completeUserNames)),
 	}
 
 	update.Flags().String("set-name", "", "user's new name")
 	update.Flags().String("set-email", "", "user's new email")
+	update.Flags().Bool("clear-email", false, "remove the user's email")
+	update.Flags().Bool("dry-run", false, "only show what would change")
 
 	delete := &cobra.Command{
-		Use:   "delete",
+		Use:   "delete <user>",
 		Short: "delete/disable a user account",
 		Args:  cobra.ExactArgs(1),
-		RunE:  cli.wrap(runUserDelete),
+		RunE:  cli.wrap(runPreHooks, runUserDelete, runPostHooks),
+
+		ValidArgsFunction: cli.wrapCompletion(firstArg(completeUserIDs)),
 	}
 
 	delete.Flags().Bool("confirm", false, "confirm deletion; dry-runs otherwise")
 
-	cmd.AddCommand(create, update, delete)
+	restore := &cobra.Command{
+		Use:   "restore <user>",
+		Short: "re-enable a disabled user account",
+		Args:  cobra.ExactArgs(1),
+		RunE:  cli.wrap(runPreHooks, runUserRestore, runPostHooks),
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "list all user accounts as a table",
+		Args:  cobra.NoArgs,
+		RunE:  cli.wrap(runUserList),
+	}
+
+	list.Flags().Bool("include-inactive", false, "include disabled users")
+	list.Flags().Bool("only-inactive", false, "only show disabled users")
+	list.Flags().Float64("min-balance", 0, "only show users with at least this balance")
+	list.Flags().Float64("max-balance", 0, "only show users with at most this balance")
+	list.Flags().String("sort", "name", "sort by name, id, balance or activity")
+	list.Flags().Bool("reverse", false, "reverse the sort order")
+
+	card := &cobra.Command{
+		Use:   "card [user...]",
+		Short: "generate cards with a user's name as a barcode",
+		Args:  cobra.ArbitraryArgs,
+		RunE:  cli.wrap(runUserCard),
+
+		ValidArgsFunction: cli.wrapCompletion(completeUserNames),
+	}
+
+	addLabelFlags(card, "qr|code128; default: qr")
+
+	cmd.AddCommand(create, update, delete, restore, list, card)
 	return cmd
 }
 
@@ -79,9 +127,14 @@ func runUserGet(cli *CLI, cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
+
+		includeInactive, _ := cmd.Flags().GetBool("include-inactive")
+		if !includeInactive {
+			users = activeUsers(users)
+		}
 	}
 
-	settings, _, err := cli.Client.Settings.Get()
+	settings, err := cli.Settings()
 	if err != nil {
 		return err
 	}
@@ -108,6 +161,13 @@ func runUserCreate(cli *CLI, cmd *cobra.Command, args []string) error {
 
 	balance := CurrencyFloat64ToInt(floatBalance)
 
+	if email != "" {
+		err := validateEmail(email)
+		if err != nil {
+			return err
+		}
+	}
+
 	user, _, err := cli.Client.User.Create(&schema.UserCreateRequest{
 		Name:  username,
 		Email: email,
@@ -116,11 +176,12 @@ func runUserCreate(cli *CLI, cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	cli.result = user
 	fmt.Printf("created user #%d (%s)\n", user.ID, user.Name)
 
 	// fake an inital balance by issuing a transaction
 	if balance != 0 {
-		settings, _, err := cli.Client.Settings.Get()
+		settings, err := cli.Settings()
 		if err != nil {
 			return err
 		}
@@ -130,6 +191,7 @@ func runUserCreate(cli *CLI, cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
+		cli.result = &tx.Issuer
 		fmt.Printf("created transaction #%d\n", tx.ID)
 		fmt.Printf("new balance for user #%d (%s): %.2f%s\n",
 			tx.Issuer.ID,
@@ -144,52 +206,133 @@ func runUserCreate(cli *CLI, cmd *cobra.Command, args []string) error {
 
 func runUserUpdate(cli *CLI, cmd *cobra.Command, args []string) error {
 
-	newUsername, _ := cmd.Flags().GetString("set-name")
-	newEmail, _ := cmd.Flags().GetString("set-email")
-	username, _ := cmd.Flags().GetString("user")
+	flags := cmd.Flags()
+
+	if flags.Changed("set-email") && flags.Changed("clear-email") {
+		return newError(ErrorUsage, "--set-email and --clear-email are mutually exclusive")
+	}
+
+	if !flags.Changed("set-name") && !flags.Changed("set-email") && !flags.Changed("clear-email") {
+		return newError(ErrorUsage, "no updates requested")
+	}
 
-	user, _, err := cli.Client.User.GetByName(username)
+	// command line has precedence
+	query, _ := flags.GetString("user")
+	if len(args) == 1 {
+		query = args[0]
+	}
+
+	user, err := resolveUser(cli, query)
 	if err != nil {
 		return err
 	}
 
-	// don't do anything if nothing changed
-	if newUsername == "" && newEmail == "" {
-		fmt.Printf("no updates requested for user #%d (%s)\n", user.ID, user.Name)
-		return cmd.Usage()
+	// the API only changes the fields that are sent
+	update := &userUpdateRequest{}
+	name, email := user.Name, ""
+	if user.Email != nil {
+		email = *user.Email
 	}
+	before := email
 
-	user, _, err = cli.Client.User.Update(user.ID, &schema.UserUpdateRequest{
-		Name:  newUsername,
-		Email: newEmail,
+	if flags.Changed("set-name") {
+		name, _ = flags.GetString("set-name")
+		if name == "" {
+			return newError(ErrorValidation, "name must not be empty")
+		}
+		if name != user.Name {
+			update.Name = name
+		}
+	}
+	if flags.Changed("set-email") {
+		email, _ = flags.GetString("set-email")
+		err = validateEmail(email)
+		if err != nil {
+			return err
+		}
+		if email != before {
+			update.Email, _ = json.Marshal(email)
+		}
+	}
+	if clear, _ := flags.GetBool("clear-email"); clear {
+		email = ""
+		if before != "" {
+			update.Email = json.RawMessage("null")
+		}
+	}
+
+	header := fmt.Sprintf("user #%d (%s):", user.ID, user.Name)
+	changed := printChanges(header, []fieldChange{
+		{"name", quoteValue(user.Name), quoteValue(name)},
+		{"email", quoteValue(before), quoteValue(email)},
 	})
+
+	if !changed {
+		fmt.Printf("no changes for user #%d (%s)\n", user.ID, user.Name)
+		return nil
+	}
+
+	dryRun, _ := flags.GetBool("dry-run")
+	if dryRun {
+		return newError(ErrorDryRun, "would update user #%d (%s)", user.ID, user.Name)
+	}
+
+	req, err := cli.Client.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/%d", schema.EndpointUser, user.ID), update)
 	if err != nil {
 		return err
 	}
 
+	var body schema.SingleUserResponse
+	_, err = cli.Client.Do(req, &body)
+	if err != nil {
+		return err
+	}
+	user = &body.User
+
+	after := ""
+	if user.Email != nil {
+		after = *user.Email
+	}
+	if user.Name != name || after != email {
+		return newError(ErrorServer, "failed to update user #%d (%s)", user.ID, user.Name)
+	}
+
+	cli.result = user
 	fmt.Printf("updated user #%d (%s)\n", user.ID, user.Name)
 	return nil
 }
 
-func runUserDelete(cli *CLI, cmd *cobra.Command, args []string) error {
+// Unlike schema.UserUpdateRequest, this can remove the email
+// address, which takes an explicit null.
+type userUpdateRequest struct {
+	Name  string          `json:"name,omitempty"`
+	Email json.RawMessage `json:"email,omitempty"`
+}
 
-	uid, err := strconv.Atoi(args[0])
-	if err != nil {
-		return err
+// Checks that email is a bare address, like "user@example.com".
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return newError(ErrorValidation, "invalid email address '%s'", email)
 	}
+	return nil
+}
+
+func runUserDelete(cli *CLI, cmd *cobra.Command, args []string) error {
 
-	user, _, err := cli.Client.User.Get(uid)
+	user, err := resolveUser(cli, args[0])
 	if err != nil {
 		return err
 	}
 
 	if !user.IsActive {
-		return fmt.Errorf("user is already disabled")
+		return newError(ErrorValidation, "user is already disabled")
 	}
 
 	confirmed, _ := cmd.Flags().GetBool("confirm")
 	if !confirmed {
-		return fmt.Errorf("dry-run: would delete user #%d (%s)",
+		return newError(ErrorDryRun, "would delete user #%d (%s)",
 			user.ID, user.Name)
 	}
 
@@ -198,10 +341,221 @@ func runUserDelete(cli *CLI, cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if !user.IsActive {
-		return fmt.Errorf("failed to disable user")
+	if user.IsActive {
+		return newError(ErrorServer, "failed to disable user")
 	}
 
+	cli.result = user
 	fmt.Printf("disabled user #%d (%s)\n", user.ID, user.Name)
 	return nil
 }
+
+func runUserRestore(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	user, err := resolveInactiveUser(cli, args[0])
+	if err != nil {
+		return err
+	}
+
+	if user.IsActive {
+		return newError(ErrorValidation, "user is already active")
+	}
+
+	// like user update, this only sends what changes;
+	// the API leaves the name and email as they are
+	active := true
+	user, _, err = cli.Client.User.Update(user.ID, &schema.UserUpdateRequest{
+		SetActive: &active,
+	})
+	if err != nil {
+		return err
+	}
+
+	if !user.IsActive {
+		return newError(ErrorServer, "failed to re-enable user")
+	}
+
+	cli.result = user
+	fmt.Printf("re-enabled user #%d (%s)\n", user.ID, user.Name)
+	return nil
+}
+
+func runUserList(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	flags := cmd.Flags()
+	sortBy, _ := flags.GetString("sort")
+	reverse, _ := flags.GetBool("reverse")
+	includeInactive, _ := flags.GetBool("include-inactive")
+	onlyInactive, _ := flags.GetBool("only-inactive")
+	minBalance, _ := flags.GetFloat64("min-balance")
+	maxBalance, _ := flags.GetFloat64("max-balance")
+
+	var less func(a, b *schema.User) bool
+	switch sortBy {
+	case "name":
+		less = func(a, b *schema.User) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case "id":
+		less = func(a, b *schema.User) bool { return a.ID < b.ID }
+	case "balance":
+		less = func(a, b *schema.User) bool { return a.Balance < b.Balance }
+	case "activity":
+		less = func(a, b *schema.User) bool { return userActivity(a).Before(userActivity(b)) }
+	default:
+		return newError(ErrorUsage, "can't sort users by '%s'", sortBy)
+	}
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return err
+	}
+
+	users, err := listUsers(cli)
+	if err != nil {
+		return err
+	}
+
+	var filtered []schema.User
+	for _, user := range users {
+		switch {
+		case onlyInactive && user.IsActive:
+			continue
+		case !onlyInactive && !includeInactive && !user.IsActive:
+			continue
+		case flags.Changed("min-balance") && user.Balance < CurrencyFloat64ToInt(minBalance):
+			continue
+		case flags.Changed("max-balance") && user.Balance > CurrencyFloat64ToInt(maxBalance):
+			continue
+		}
+		filtered = append(filtered, user)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if reverse {
+			return less(&filtered[j], &filtered[i])
+		}
+		return less(&filtered[i], &filtered[j])
+	})
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "ID\tNAME\tBALANCE\tACTIVE\tEMAIL\tLAST ACTIVE")
+	for _, user := range filtered {
+		email := ""
+		if user.Email != nil {
+			email = *user.Email
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%t\t%s\t%s\n",
+			user.ID,
+			user.Name,
+			formatCurrency(settings, user.Balance),
+			user.IsActive,
+			email,
+			formatTime(userActivity(&user)),
+		)
+	}
+	return w.Flush()
+}
+
+func runUserCard(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	all, _ := cmd.Flags().GetBool("all")
+	format, _ := cmd.Flags().GetString("format")
+	dest, _ := cmd.Flags().GetString("dest")
+	kind, _ := cmd.Flags().GetString("type")
+
+	if kind == "" {
+		kind = "qr"
+	}
+	if kind != "qr" && kind != "code128" {
+		return newError(ErrorUsage, "unknown barcode type '%s'", kind)
+	}
+	if len(args) == 0 && !all {
+		return newError(ErrorUsage, "no users given; use --all for all of them")
+	}
+
+	var users []*schema.User
+	if all {
+		list, err := listUsers(cli)
+		if err != nil {
+			return err
+		}
+		for _, user := range activeUsers(list) {
+			user := user
+			users = append(users, &user)
+		}
+	}
+	for _, arg := range args {
+		user, err := resolveUser(cli, arg)
+		if err != nil {
+			return err
+		}
+		users = append(users, user)
+	}
+
+	var labels []label
+	for _, user := range users {
+		// names are what commands like buy --user resolve best
+		code, err := encodeBarcode(kind, user.Name)
+		if err != nil {
+			return err
+		}
+
+		labels = append(labels, label{
+			File:     fmt.Sprintf("user-%d", user.ID),
+			Title:    user.Name,
+			Subtitle: fmt.Sprintf("user #%d", user.ID),
+			Code:     code,
+		})
+	}
+	return writeLabels(labels, userCardLayout, format, dest, "user-cards.pdf")
+}
+
+// Users are updated by transactions, so that's their last activity.
+func userActivity(user *schema.User) time.Time {
+	if time.Time(user.TimeUpdated).IsZero() {
+		return localTime(user.TimeCreated)
+	}
+	return localTime(user.TimeUpdated)
+}
+
+func activeUsers(users []schema.User) []schema.User {
+	var active []schema.User
+	for _, user := range users {
+		if user.IsActive {
+			active = append(active, user)
+		}
+	}
+	return active
+}
+
+func inactiveUsers(users []schema.User) []schema.User {
+	var inactive []schema.User
+	for _, user := range users {
+		if !user.IsActive {
+			inactive = append(inactive, user)
+		}
+	}
+	return inactive
+}
+
+// Fetches all users, including inactive ones, page by page.
+func listUsers(cli *CLI) ([]schema.User, error) {
+
+	var users []schema.User
+	for page := uint(1); ; page++ {
+		batch, _, err := cli.Client.User.List(&s.ListOpts{Page: page, PerPage: listPageSize})
+		if err != nil {
+			return nil, err
+		}
+
+		// guard against servers that ignore pagination
+		if len(batch) > 0 && len(users) > 0 && batch[0].ID == users[0].ID {
+			break
+		}
+
+		users = append(users, batch...)
+		if len(batch) < listPageSize {
+			break
+		}
+	}
+	return users, nil
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"github.com/jktr/go-strichliste/schema"
+	"strings"
+)
+
+// The server would reject invalid transactions anyway, but checking
+// them against its settings beforehand yields more helpful errors.
+
+// Checks a deposit (positive amount) or withdrawal (negative amount).
+func validateDelta(settings *schema.Settings, user *schema.User, amount int) error {
+
+	kind, preset, abs := "deposit", settings.Payment.Deposit, amount
+	if amount < 0 {
+		kind, preset, abs = "withdrawal", settings.Payment.Withdraw, -amount
+	}
+
+	if !preset.IsEnabled {
+		return newError(ErrorValidation, "%ss are disabled on this server", kind)
+	}
+
+	if !preset.AllowCustomAmount && !containsInt(preset.PresetAmounts, abs) {
+		var presets []string
+		for _, p := range preset.PresetAmounts {
+			presets = append(presets, formatCurrency(settings, p))
+		}
+		return newError(ErrorValidation, "custom %s amounts are disabled; choose one of: %s",
+			kind, strings.Join(presets, ", "))
+	}
+
+	err := validatePaymentLimit(settings, amount)
+	if err != nil {
+		return err
+	}
+	return validateAccountLimit(settings, user, amount)
+}
+
+// Checks a transfer of funds; amount is negative, as sent to the server.
+func validateTransfer(settings *schema.Settings, from, to *schema.User, amount int) error {
+
+	if !settings.Payment.TransferFunds.IsEnabled {
+		return newError(ErrorValidation, "user-to-user transfers are disabled on this server")
+	}
+
+	err := validatePaymentLimit(settings, amount)
+	if err != nil {
+		return err
+	}
+
+	err = validateAccountLimit(settings, from, amount)
+	if err != nil {
+		return err
+	}
+	return validateAccountLimit(settings, to, -amount)
+}
+
+// Checks the purchase of count instances of an article.
+func validatePurchase(settings *schema.Settings, user *schema.User, article *schema.Article, count int) error {
+
+	if count <= 0 {
+		return newError(ErrorValidation, "must buy at least one instance of the article")
+	}
+
+	if !article.IsActive {
+		return newError(ErrorValidation, "article #%d (%s) is disabled", article.ID, article.Name)
+	}
+
+	amount := -article.Value * count
+
+	err := validatePaymentLimit(settings, amount)
+	if err != nil {
+		return err
+	}
+	return validateAccountLimit(settings, user, amount)
+}
+
+func validatePaymentLimit(settings *schema.Settings, amount int) error {
+	limit := settings.Payment.Limit
+	if !withinLimit(limit, amount) {
+		return newError(ErrorLimit, "amount %s is outside the allowed transaction range [%s, %s]",
+			formatCurrency(settings, amount),
+			formatCurrency(settings, limit.Lower),
+			formatCurrency(settings, limit.Upper),
+		)
+	}
+	return nil
+}
+
+func validateAccountLimit(settings *schema.Settings, user *schema.User, amount int) error {
+	limit := settings.Account.Limit
+	balance := user.Balance + amount
+	if !withinLimit(limit, balance) {
+		return newError(ErrorLimit, "new balance %s for user #%d (%s) would be outside the allowed range [%s, %s]",
+			formatCurrency(settings, balance),
+			user.ID,
+			user.Name,
+			formatCurrency(settings, limit.Lower),
+			formatCurrency(settings, limit.Upper),
+		)
+	}
+	return nil
+}
+
+// An unset limit (both bounds zero) allows anything.
+func withinLimit(limit schema.Limit, value int) bool {
+	if limit.Lower == 0 && limit.Upper == 0 {
+		return true
+	}
+	return limit.Lower <= value && value <= limit.Upper
+}
+
+func containsInt(xs []int, x int) bool {
+	for _, y := range xs {
+		if x == y {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(xs []string, x string) bool {
+	for _, y := range xs {
+		if x == y {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,294 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	s "github.com/jktr/go-strichliste"
+	"github.com/jktr/go-strichliste/schema"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How many transactions to fetch per poll; more are paged in
+// if they all turn out to be new.
+const watchPageSize = 50
+
+// A transaction as printed by watch --json, one per line.
+type watchEvent struct {
+	ID          int       `json:"id"`
+	Time        time.Time `json:"time"`
+	User        string    `json:"user"`
+	UserID      int       `json:"userId"`
+	Amount      float64   `json:"amount"`
+	Balance     float64   `json:"balance"`
+	Description string    `json:"description"`
+	Comment     string    `json:"comment,omitempty"`
+	Article     string    `json:"article,omitempty"`
+	ArticleID   int       `json:"articleId,omitempty"`
+	Quantity    int       `json:"quantity,omitempty"`
+	Recipient   string    `json:"recipient,omitempty"`
+	RecipientID int       `json:"recipientId,omitempty"`
+	Reversed    bool      `json:"reversed"`
+}
+
+func newWatchCommand(cli *CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch [user...]",
+		Short: "print new transactions as they happen (default: of all users)",
+		Long: `print new transactions as they happen (default: of all users)
+
+Each transaction is printed once, when it's first seen. Reverting a
+transaction marks it as reversed rather than creating a new one, so
+reverts of transactions that were already printed aren't reported.`,
+		Args: cobra.ArbitraryArgs,
+		RunE: cli.wrap(runWatch),
+
+		ValidArgsFunction: cli.wrapCompletion(completeUserNames),
+	}
+
+	cmd.Flags().Duration("interval", 5*time.Second, "how often to poll for new transactions")
+	cmd.Flags().Bool("json", false, "print transactions as JSON, one per line")
+	cmd.Flags().Int("since", -1, "start after this transaction ID instead of where the last watch left off")
+
+	return cmd
+}
+
+func runWatch(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	interval, _ := cmd.Flags().GetDuration("interval")
+	asJSON, _ := cmd.Flags().GetBool("json")
+	since, _ := cmd.Flags().GetInt("since")
+
+	if interval <= 0 {
+		return newError(ErrorValidation, "interval must be positive")
+	}
+
+	var users []*schema.User
+	for _, arg := range args {
+		user, err := resolveUser(cli, arg)
+		if err != nil {
+			return err
+		}
+		users = append(users, user)
+	}
+
+	settings, err := cli.Settings()
+	if err != nil {
+		return err
+	}
+
+	// where we left off is tracked per server and set of users
+	key := watchKey(cli, users)
+	last, seen := loadWatchState(key)
+	if since >= 0 {
+		last, seen = since, true
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		txs, err := pollTransactions(cli, users, last)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: polling failed: %s\n", err)
+		} else {
+			for i := range txs {
+				if seen {
+					printWatchEvent(settings, &txs[i], asJSON)
+				}
+				last = txs[i].ID
+			}
+
+			// on the very first run, only skip past what's already there
+			seen = true
+			if len(txs) > 0 {
+				saveWatchState(key, last)
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Returns transactions newer than last, oldest first.
+func pollTransactions(cli *CLI, users []*schema.User, last int) ([]schema.Transaction, error) {
+
+	var fetch []func(*s.ListOpts) ([]schema.Transaction, *s.Response, error)
+	if len(users) == 0 {
+		fetch = append(fetch, cli.Client.Transaction.List)
+	}
+	for _, user := range users {
+		fetch = append(fetch, cli.Client.Transaction.Context(user.ID).List)
+	}
+
+	var txs []schema.Transaction
+	for _, f := range fetch {
+		first := 0 // of the previous page
+		for page := uint(1); ; page++ {
+			batch, _, err := f(&s.ListOpts{Page: page, PerPage: watchPageSize})
+			if err != nil {
+				return nil, err
+			}
+
+			// guard against servers that ignore pagination
+			if len(batch) > 0 && batch[0].ID == first {
+				break
+			}
+			if len(batch) > 0 {
+				first = batch[0].ID
+			}
+
+			older := false
+			for _, tx := range batch {
+				if tx.ID > last {
+					txs = append(txs, tx)
+				} else {
+					older = true
+				}
+			}
+
+			// without a baseline, the newest page suffices
+			if older || last < 0 || len(batch) < watchPageSize {
+				break
+			}
+		}
+	}
+
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].ID < txs[j].ID
+	})
+
+	// a transfer shows up with both of the watched users
+	var unique []schema.Transaction
+	for _, tx := range txs {
+		if len(unique) == 0 || unique[len(unique)-1].ID != tx.ID {
+			unique = append(unique, tx)
+		}
+	}
+	return unique, nil
+}
+
+func printWatchEvent(settings *schema.Settings, tx *schema.Transaction, asJSON bool) {
+
+	created := localTime(tx.TimeCreated)
+
+	if !asJSON {
+		line := fmt.Sprintf("%s  #%d  %s  %s  %s",
+			formatTime(created),
+			tx.ID,
+			tx.Issuer.Name,
+			formatCurrency(settings, tx.Value),
+			describeTransaction(tx),
+		)
+		if tx.Comment != "" && describeTransaction(tx) != tx.Comment {
+			line += fmt.Sprintf(" (%s)", tx.Comment)
+		}
+		if tx.IsReversed {
+			line += " [reversed]"
+		}
+		fmt.Println(strings.TrimSpace(line))
+		return
+	}
+
+	event := &watchEvent{
+		ID:          tx.ID,
+		Time:        created,
+		User:        tx.Issuer.Name,
+		UserID:      tx.Issuer.ID,
+		Amount:      CurrencyIntToFloat64(tx.Value),
+		Balance:     CurrencyIntToFloat64(tx.Issuer.Balance),
+		Description: describeTransaction(tx),
+		Comment:     tx.Comment,
+		Reversed:    tx.IsReversed,
+	}
+	if tx.Article != nil {
+		event.Article = tx.Article.Name
+		event.ArticleID = tx.Article.ID
+	}
+	if tx.Quantity != nil {
+		event.Quantity = *tx.Quantity
+	}
+	if tx.To != nil {
+		event.Recipient = tx.To.Name
+		event.RecipientID = tx.To.ID
+	}
+	json.NewEncoder(os.Stdout).Encode(event)
+}
+
+func watchKey(cli *CLI, users []*schema.User) string {
+
+	scope := "all"
+	if len(users) > 0 {
+		var ids []int
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		sort.Ints(ids)
+
+		var parts []string
+		for _, id := range ids {
+			parts = append(parts, strconv.Itoa(id))
+		}
+		scope = "users " + strings.Join(parts, ",")
+	}
+	return cli.Viper.GetString("api-url") + " " + scope
+}
+
+// Returns the last transaction ID seen by a previous watch, if any.
+func loadWatchState(key string) (int, bool) {
+
+	path, err := statePath("watch.json")
+	if err != nil {
+		return -1, false
+	}
+
+	state := map[string]int{}
+	if buf, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(buf, &state)
+	}
+
+	last, ok := state[key]
+	if !ok {
+		return -1, false
+	}
+	return last, true
+}
+
+func saveWatchState(key string, last int) {
+
+	path, err := statePath("watch.json")
+	if err != nil {
+		return
+	}
+
+	state := map[string]int{}
+	if buf, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(buf, &state)
+	}
+	state[key] = last
+
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+
+	// write atomically, so an interrupted watch can't lose its place
+	tmp := path + ".tmp"
+	if ioutil.WriteFile(tmp, buf, 0600) == nil {
+		os.Rename(tmp, path)
+	}
+}
@@ -0,0 +1,390 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/jktr/go-strichliste/schema"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Webhooks are HTTP endpoints notified of transactions, as configured
+// under "webhooks" in the config file:
+//
+//	"webhooks": [
+//	  {
+//	    "url": "https://example.org/strichliste",
+//	    "secret": "...",
+//	    "events": ["purchase", "deposit", "withdrawal", "transfer", "revert"]
+//	  }
+//	]
+//
+// Without events, all of them are delivered. Each delivery is a POST
+// of a webhookPayload; if a secret is set, the body's HMAC-SHA256 is
+// sent as "X-Strichliste-Signature: sha256=<hex>". Failed deliveries
+// are retried with backoff, and then appended to a dead-letter file,
+// from which "webhooks retry" redelivers them.
+type webhookConfig struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+type webhookPayload struct {
+	Delivery    string              `json:"delivery"`
+	Event       string              `json:"event"`
+	Time        time.Time           `json:"time"`
+	Transaction *schema.Transaction `json:"transaction"`
+	Issuer      *webhookUser        `json:"issuer"`
+	Recipient   *webhookUser        `json:"recipient,omitempty"`
+}
+
+type webhookUser struct {
+	ID      int     `json:"id"`
+	Name    string  `json:"name"`
+	Balance float64 `json:"balance"`
+}
+
+// A delivery that failed for good, as stored in the dead-letter file.
+type deadLetter struct {
+	URL      string          `json:"url"`
+	Event    string          `json:"event"`
+	Delivery string          `json:"delivery"`
+	Payload  json.RawMessage `json:"payload"`
+	Error    string          `json:"error"`
+	Time     time.Time       `json:"time"`
+}
+
+const deadLetterFile = "webhooks-failed.jsonl"
+
+// Used for webhooks and other notifications; unlike the API client,
+// it doesn't retry or trace requests.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func newWebhooksCommand(cli *CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "webhooks",
+		Short: "manage failed webhook deliveries",
+		Args:  cobra.NoArgs,
+		RunE:  func(cmd *cobra.Command, _ []string) error { return cmd.Usage() },
+	}
+
+	failed := &cobra.Command{
+		Use:   "failed",
+		Short: "list deliveries that failed for good",
+		Args:  cobra.NoArgs,
+		RunE:  cli.wrap(runWebhooksFailed),
+	}
+
+	retry := &cobra.Command{
+		Use:   "retry",
+		Short: "redeliver failed deliveries",
+		Args:  cobra.NoArgs,
+		RunE:  cli.wrap(runWebhooksRetry),
+	}
+
+	cmd.AddCommand(failed, retry)
+	return cmd
+}
+
+// Classifies a transaction by what happened to the issuer's balance.
+func webhookEvent(tx *schema.Transaction) string {
+	switch {
+	case tx.IsReversed:
+		return "revert"
+	case tx.Article != nil:
+		return "purchase"
+	case tx.To != nil || tx.From != nil:
+		return "transfer"
+	case tx.Value >= 0:
+		return "deposit"
+	}
+	return "withdrawal"
+}
+
+// Delivers a transaction to all interested webhooks in the background,
+// so retries don't hold up the command; CLI.Execute waits for them
+// before exiting. Delivery is best effort; failures are reported and
+// dead-lettered.
+func (c *CLI) deliverWebhooks(tx *schema.Transaction) {
+
+	var hooks []webhookConfig
+	err := c.Viper.UnmarshalKey("webhooks", &hooks)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: invalid webhooks config: %s\n", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload := &webhookPayload{
+		Delivery:    newDeliveryID(),
+		Event:       webhookEvent(tx),
+		Time:        time.Now(),
+		Transaction: tx,
+		Issuer:      newWebhookUser(&tx.Issuer),
+	}
+	if tx.To != nil {
+		payload.Recipient = newWebhookUser(tx.To)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	retries := c.Viper.GetInt("retries")
+	for _, hook := range hooks {
+		if len(hook.Events) > 0 && !containsString(hook.Events, payload.Event) {
+			continue
+		}
+
+		c.deliveries.Add(1)
+		go func(hook webhookConfig) {
+			defer c.deliveries.Done()
+
+			err := postWebhook(&hook, payload.Event, payload.Delivery, body, retries)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: webhook %s failed: %s\n", hook.URL, err)
+				saveDeadLetter(&deadLetter{
+					URL:      hook.URL,
+					Event:    payload.Event,
+					Delivery: payload.Delivery,
+					Payload:  body,
+					Error:    err.Error(),
+					Time:     time.Now(),
+				})
+			}
+		}(hook)
+	}
+}
+
+func newWebhookUser(user *schema.User) *webhookUser {
+	return &webhookUser{
+		ID:      user.ID,
+		Name:    user.Name,
+		Balance: CurrencyIntToFloat64(user.Balance),
+	}
+}
+
+func newDeliveryID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Posts a payload, retrying with backoff on network errors, rate
+// limits and server errors. Other client errors are final.
+func postWebhook(hook *webhookConfig, event, delivery string, body []byte, retries int) error {
+
+	delay := time.Second
+	for i := 0; ; i++ {
+		req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Strichliste-Event", event)
+		req.Header.Set("X-Strichliste-Delivery", delivery)
+		if hook.Secret != "" {
+			mac := hmac.New(sha256.New, []byte(hook.Secret))
+			mac.Write(body)
+			req.Header.Set("X-Strichliste-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+
+		retry := true
+		resp, err := webhookClient.Do(req)
+		if err == nil {
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			switch {
+			case resp.StatusCode/100 == 2:
+				return nil
+			case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+			default:
+				retry = false
+			}
+			err = fmt.Errorf("webhook responded with status code %d", resp.StatusCode)
+		}
+
+		if !retry || i >= retries {
+			return err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func saveDeadLetter(letter *deadLetter) {
+
+	path, err := statePath(deadLetterFile)
+	if err != nil {
+		return
+	}
+
+	// webhooks retry may be rewriting the file
+	unlock, err := lockState(deadLetterFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: can't save failed delivery: %s\n", err)
+		return
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: can't save failed delivery: %s\n", err)
+		return
+	}
+	defer f.Close()
+
+	json.NewEncoder(f).Encode(letter)
+}
+
+func loadDeadLetters() ([]deadLetter, error) {
+
+	path, err := statePath(deadLetterFile)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var letters []deadLetter
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var letter deadLetter
+		if json.Unmarshal(scanner.Bytes(), &letter) == nil {
+			letters = append(letters, letter)
+		}
+	}
+	return letters, scanner.Err()
+}
+
+func runWebhooksFailed(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	letters, err := loadDeadLetters()
+	if err != nil {
+		return err
+	}
+
+	w := newTableWriter()
+	fmt.Fprintln(w, "TIME\tEVENT\tURL\tERROR")
+	for _, letter := range letters {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			formatTime(letter.Time), letter.Event, letter.URL, letter.Error)
+	}
+	return w.Flush()
+}
+
+func runWebhooksRetry(cli *CLI, cmd *cobra.Command, args []string) error {
+
+	unlock, err := lockState(deadLetterFile)
+	if err != nil {
+		return err
+	}
+	letters, err := loadDeadLetters()
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	var hooks []webhookConfig
+	err = cli.Viper.UnmarshalKey("webhooks", &hooks)
+	if err != nil {
+		return newError(ErrorUsage, "invalid webhooks config: %s", err)
+	}
+
+	// secrets may have been rotated, so use the current ones
+	secrets := map[string]string{}
+	for _, hook := range hooks {
+		secrets[hook.URL] = hook.Secret
+	}
+
+	// a letter is identified by its delivery and URL, as a delivery
+	// goes to every webhook
+	done := map[string]bool{}
+	failed := map[string]deadLetter{}
+	for _, letter := range letters {
+		hook := &webhookConfig{URL: letter.URL, Secret: secrets[letter.URL]}
+		err := postWebhook(hook, letter.Event, letter.Delivery, letter.Payload, cli.Viper.GetInt("retries"))
+		if err != nil {
+			letter.Error = err.Error()
+			failed[letter.Delivery+" "+letter.URL] = letter
+			continue
+		}
+		done[letter.Delivery+" "+letter.URL] = true
+		fmt.Printf("redelivered %s to %s\n", letter.Event, letter.URL)
+	}
+
+	err = removeDeadLetters(done, failed)
+	if err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return newError(ErrorNetwork, "%d of %d deliveries failed again", len(failed), len(letters))
+	}
+	return nil
+}
+
+// Rewrites the dead-letter file without the redelivered letters and
+// with the new errors of the failed ones. Others may have been added
+// by the gateway, bot or bridge meanwhile, so it's reread under lock.
+func removeDeadLetters(done map[string]bool, failed map[string]deadLetter) error {
+
+	unlock, err := lockState(deadLetterFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	letters, err := loadDeadLetters()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, letter := range letters {
+		key := letter.Delivery + " " + letter.URL
+		if done[key] {
+			continue
+		}
+		if f, ok := failed[key]; ok {
+			letter = f
+		}
+		json.NewEncoder(&buf).Encode(&letter)
+	}
+
+	path, err := statePath(deadLetterFile)
+	if err != nil {
+		return err
+	}
+
+	// write atomically, so an interrupted write can't lose letters
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -4,8 +4,11 @@ go 1.12
 
 require (
 	github.com/BurntSushi/toml v0.3.1 // indirect
+	github.com/boombuler/barcode v1.0.1
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/jktr/go-strichliste v0.3.0
-	github.com/spf13/cobra v0.0.3
+	github.com/peterh/liner v1.2.1
+	github.com/spf13/cobra v1.5.0
+	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.3.2
 )